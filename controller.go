@@ -1,85 +1,351 @@
 package main
 
 import (
+	"fmt"
 	"math"
 	"sort"
 	"sync"
 )
 
+// interpolation is the method used to calculate
+// the duty-cycle between two mapping points.
+type interpolation string
+
+const (
+	// InterpolationStep use the duty-cycle of the last mapping point
+	// at or below the current temp.
+	InterpolationStep interpolation = "step"
+	// InterpolationLinear calculate the duty-cycle on the straight
+	// line between the two surrounding mapping points.
+	InterpolationLinear interpolation = "linear"
+	// InterpolationSmoothstep is like linear but eases in and out
+	// of the two surrounding mapping points.
+	InterpolationSmoothstep interpolation = "smoothstep"
+)
+
 type targetData struct {
 	dutyCycle          uint8
 	lastUpdatedTemp    float64
 	sortedMappingTemps []float64
+
+	// rpm is the needed rpm for the targets
+	// mapped in rpm instead of duty-cycle.
+	rpm    uint16
+	useRPM bool
 }
 
+// value return the needed duty-cycle or rpm.
+func (td *targetData) value() float64 {
+	if td.useRPM {
+		return float64(td.rpm)
+	}
+	return float64(td.dutyCycle)
+}
+
+// setValue set the needed duty-cycle or rpm.
+func (td *targetData) setValue(v float64) {
+	if td.useRPM {
+		td.rpm = uint16(math.Round(v))
+		return
+	}
+	td.dutyCycle = uint8(math.Round(v))
+}
+
+// controllerMode is the method used
+// to calculate the needed duty-cycle.
+type controllerMode string
+
+const (
+	// ModeMapping use the temp/duty-cycle mappings in Targets.
+	ModeMapping controllerMode = "mapping"
+	// ModePID drive the duty-cycle of every target toward a temp setpoint.
+	ModePID controllerMode = "pid"
+)
+
+// errorPolicy is the behaviour of a controller
+// when its temp source fails.
+type errorPolicy string
+
+const (
+	// OnErrorHold keep the last duty-cycle.
+	OnErrorHold errorPolicy = "hold"
+	// OnErrorFailsafe set the FailsafeDuty duty-cycle.
+	OnErrorFailsafe errorPolicy = "failsafe"
+	// OnErrorMax set the maximum duty-cycle after MaxAfterErrors consecutive failures.
+	OnErrorMax errorPolicy = "max"
+)
+
 // commanderpro, ipmi, cli
 type controller struct {
 	Name string `yaml:"name"`
 
-	// commanderpro, ipmi, cli
-	// commanderpro: sensor_channel (uint8 as string), ipmi: entityID, cli: custom_command
-	Temp struct {
-		Method string
-		Arg    string
-	} `yaml:"temp"`
+	// Temp is the temperature source, a single sensor or a composite of several sensors.
+	Temp tempSource `yaml:"temp"`
+
+	// OnError is the policy used when the temp source fails:
+	// hold (default), failsafe or max.
+	OnError errorPolicy `yaml:"on_error"`
+
+	// FailsafeDuty is the duty-cycle used by the failsafe policy (1-100),
+	// required by the failsafe policy.
+	FailsafeDuty uint8 `yaml:"failsafe_duty"`
+
+	// MaxAfterErrors is the number of consecutive failures
+	// after which the max policy set 100%, the last duty-cycle is held before.
+	MaxAfterErrors int `yaml:"max_after_errors"`
+
+	// Filter is an optional smoothing filter for the temp readings.
+	Filter *filter `yaml:"filter"`
+
+	// Mode is mapping (default) or pid.
+	Mode controllerMode `yaml:"mode"`
+
+	// PID is the pid mode configuration,
+	// pid keys are placed at the controller level.
+	PID pid `yaml:",inline"`
 
 	// MinTempChange is the minimum necessary change (in °C)
 	// from the last duty cycle update to actually cause another update.
+	// It is ignored when HysteresisDown is set.
 	MinTempChange float64 `yaml:"min_temp_change"`
 
+	// HysteresisDown replace the MinTempChange deadband with an asymmetric one:
+	// the duty-cycle goes up as soon as a mapping point is crossed upwards,
+	// but it goes down only when the temp falls more than HysteresisDown (in °C) below that point.
+	HysteresisDown float64 `yaml:"hysteresis_down"`
+
+	// Interpolation is the method used to calculate the duty-cycle
+	// between two mapping points: step (default), linear or smoothstep.
+	Interpolation interpolation `yaml:"interpolation"`
+
 	// Targets are the ipmi zone target with their temp/duty-cycle mapping.
 	// cpu_zone: 0x00, io_zone: 0x01.
+	// In pid mode mappings are ignored and can be left empty.
 	// target : channel : mappings
 	Targets map[string]map[float64]uint8 `yaml:"targets"`
 
+	// RPMTargets are like Targets but with a temp/rpm mapping,
+	// for the fanControllers supporting rpm control.
+	// A target must be mapped in duty-cycle or rpm in all the controllers.
+	RPMTargets map[string]map[float64]uint16 `yaml:"rpm_targets"`
+
 	// runtime vars -----------------------------------
 
 	once sync.Once
 
+	// Targets and RPMTargets mappings
+	mappings map[string]map[float64]float64
+
 	// <target:channel> : targetData
 	targetsData map[string]*targetData
+
+	// consecutive temp source failures
+	tempErrors int
+
+	// computed is true after the first successful reading
+	computed bool
+}
+
+// prepare the targets data and mappings.
+func (c *controller) prepare() {
+	c.targetsData = make(map[string]*targetData)
+	c.mappings = make(map[string]map[float64]float64)
+
+	add := func(target string, mapping map[float64]float64, useRPM bool) {
+		temps := make([]float64, 0)
+		for t := range mapping {
+			temps = append(temps, t)
+		}
+		sort.Float64s(temps)
+
+		c.mappings[target] = mapping
+		c.targetsData[target] = &targetData{
+			dutyCycle:          0,
+			lastUpdatedTemp:    0,
+			sortedMappingTemps: temps,
+			useRPM:             useRPM,
+		}
+	}
+
+	for target, mappings := range c.Targets {
+		mapping := make(map[float64]float64)
+		for t, dc := range mappings {
+			mapping[t] = float64(dc)
+		}
+		add(target, mapping, false)
+	}
+
+	for target, mappings := range c.RPMTargets {
+		mapping := make(map[float64]float64)
+		for t, rpm := range mappings {
+			mapping[t] = float64(rpm)
+		}
+		add(target, mapping, true)
+	}
 }
 
-// Calculate the needed duty-cycle for any target.
+// Calculate the needed duty-cycle (or rpm) for any target.
 // Takes in consideration the last
 // measured temp before the last update.
 func (c *controller) getNeededDutyCycles(curTemp float64) map[string]*targetData {
-	// prepare
-	c.once.Do(func() {
-		c.targetsData = make(map[string]*targetData)
+	c.once.Do(c.prepare)
+	c.computed = true
+
+	if c.Mode == ModePID {
+		dc := c.PID.update(curTemp)
+		for _, td := range c.targetsData {
+			td.dutyCycle = dc
+			td.lastUpdatedTemp = curTemp
+		}
+		return c.targetsData
+	}
 
-		for target, mappings := range c.Targets {
+	for target, mapping := range c.mappings {
+		td := c.targetsData[target]
 
-			temps := make([]float64, 0)
-			for t := range mappings {
-				temps = append(temps, t)
-			}
-			sort.Float64s(temps)
+		if c.HysteresisDown > 0 {
+			c.applyHysteresis(td, curTemp, mapping)
+			continue
+		}
 
-			c.targetsData[target] = &targetData{
-				dutyCycle:          0,
-				lastUpdatedTemp:    0,
-				sortedMappingTemps: temps,
+		if math.Abs(curTemp-td.lastUpdatedTemp) >= c.MinTempChange {
+
+			td.lastUpdatedTemp = curTemp
+
+			if v, ok := c.interpolate(curTemp, td.sortedMappingTemps, mapping); ok {
+				td.setValue(v)
 			}
 		}
-	})
+	}
 
-	for target, mappings := range c.Targets {
+	return c.targetsData
+}
+
+// onTempError return the duty-cycle (or rpm) needed for any target
+// when the temp source fails, according to the OnError policy.
+// The failsafe and max policies use duty-cycles for the rpm targets too,
+// hold (and max before MaxAfterErrors) return nothing before the first
+// successful reading, so that the targets are left alone instead of stopped.
+func (c *controller) onTempError() map[string]*targetData {
+	c.once.Do(c.prepare)
+	c.tempErrors++
+
+	switch {
+	case c.OnError == OnErrorFailsafe:
+		return c.fixedDutyCycles(c.FailsafeDuty)
+	case c.OnError == OnErrorMax && c.tempErrors >= c.MaxAfterErrors:
+		return c.fixedDutyCycles(100)
+	case !c.computed:
+		return nil
+	default:
+		return c.targetsData
+	}
+}
 
-		lastTemp := c.targetsData[target].lastUpdatedTemp
-		if math.Abs(curTemp-lastTemp) >= c.MinTempChange {
+// fixedDutyCycles return the given duty-cycle for any target.
+func (c *controller) fixedDutyCycles(dc uint8) map[string]*targetData {
+	targetsData := make(map[string]*targetData)
+	for target := range c.targetsData {
+		targetsData[target] = &targetData{dutyCycle: dc}
+	}
+	return targetsData
+}
 
-			c.targetsData[target].lastUpdatedTemp = curTemp
+// onTempRecovered reset the temp source failures count
+// returning the number of failures before the recovery.
+func (c *controller) onTempRecovered() (failures int) {
+	failures, c.tempErrors = c.tempErrors, 0
+	return
+}
 
-			for _, temp := range c.targetsData[target].sortedMappingTemps {
+// applyHysteresis raise the duty-cycle as soon as the curve asks for it,
+// lowering it only when the curve shifted by HysteresisDown asks for less.
+func (c *controller) applyHysteresis(td *targetData, curTemp float64, mapping map[float64]float64) {
+	if up, ok := c.interpolate(curTemp, td.sortedMappingTemps, mapping); ok && math.Round(up) > td.value() {
+		td.setValue(up)
+		td.lastUpdatedTemp = curTemp
+	} else if down, ok := c.interpolate(curTemp+c.HysteresisDown, td.sortedMappingTemps, mapping); ok && math.Round(down) < td.value() {
+		td.setValue(down)
+		td.lastUpdatedTemp = curTemp
+	}
+}
 
-				if temp > curTemp {
-					break
-				}
-				c.targetsData[target].dutyCycle = mappings[temp]
-			}
+// interpolate return the duty-cycle (or rpm) for the given temp using the controller
+// interpolation method, ok is false if curTemp is below the first mapping point.
+func (c *controller) interpolate(curTemp float64, temps []float64, mapping map[float64]float64) (v float64, ok bool) {
+	// index of the last mapping point at or below curTemp
+	lower := -1
+	for i, temp := range temps {
+		if temp > curTemp {
+			break
 		}
+		lower = i
 	}
 
-	return c.targetsData
+	if lower < 0 {
+		return 0, false
+	}
+
+	v = mapping[temps[lower]]
+	if c.Interpolation == "" || c.Interpolation == InterpolationStep || lower == len(temps)-1 {
+		return v, true
+	}
+
+	t0, t1 := temps[lower], temps[lower+1]
+	v0, v1 := v, mapping[t1]
+
+	x := (curTemp - t0) / (t1 - t0)
+	if c.Interpolation == InterpolationSmoothstep {
+		x = x * x * (3 - 2*x)
+	}
+
+	return v0 + (v1-v0)*x, true
+}
+
+// validate check the controller configuration.
+func (c *controller) validate() error {
+	if err := c.Temp.validate(); err != nil {
+		return fmt.Errorf("controller %s: %v", c.Name, err)
+	}
+
+	switch c.Mode {
+	case "", ModeMapping:
+	case ModePID:
+		if len(c.RPMTargets) > 0 {
+			return fmt.Errorf("controller %s: rpm_targets are not supported in pid mode", c.Name)
+		}
+		if min, max := c.PID.limits(); min > max || max > 100 {
+			return fmt.Errorf("controller %s: min_duty and max_duty must be in the 0-100 range, min_duty <= max_duty", c.Name)
+		}
+	default:
+		return fmt.Errorf("controller %s: unknown mode `%s`, use mapping or pid", c.Name, c.Mode)
+	}
+
+	switch c.Interpolation {
+	case "", InterpolationStep, InterpolationLinear, InterpolationSmoothstep:
+	default:
+		return fmt.Errorf("controller %s: unknown interpolation `%s`, use step, linear or smoothstep", c.Name, c.Interpolation)
+	}
+
+	switch c.OnError {
+	case "", OnErrorHold, OnErrorMax:
+	case OnErrorFailsafe:
+		// an unset failsafe_duty would stop the fans on errors
+		if c.FailsafeDuty == 0 || c.FailsafeDuty > 100 {
+			return fmt.Errorf("controller %s: the failsafe policy needs a failsafe_duty in the 1-100 range", c.Name)
+		}
+	default:
+		return fmt.Errorf("controller %s: unknown on_error policy `%s`, use hold, failsafe or max", c.Name, c.OnError)
+	}
+
+	if c.Filter != nil {
+		if err := c.Filter.validate(); err != nil {
+			return fmt.Errorf("controller %s: %v", c.Name, err)
+		}
+	}
+
+	if c.HysteresisDown < 0 {
+		return fmt.Errorf("controller %s: hysteresis_down must not be negative", c.Name)
+	}
+	return nil
 }
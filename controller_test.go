@@ -44,3 +44,163 @@ func Test_controller_getNeededDutyCycles(t *testing.T) {
 		})
 	}
 }
+
+func Test_controller_interpolation(t *testing.T) {
+	tests := []struct {
+		name          string
+		interpolation interpolation
+		temp          float64
+		want          uint8
+	}{
+		{name: "step below first point", interpolation: InterpolationStep, temp: 5, want: 0},
+		{name: "step", interpolation: InterpolationStep, temp: 35, want: 30},
+		{name: "linear", interpolation: InterpolationLinear, temp: 35, want: 50},
+		{name: "linear on point", interpolation: InterpolationLinear, temp: 40, want: 70},
+		{name: "linear above last point", interpolation: InterpolationLinear, temp: 80, want: 100},
+		{name: "smoothstep middle", interpolation: InterpolationSmoothstep, temp: 35, want: 50},
+		{name: "smoothstep quarter", interpolation: InterpolationSmoothstep, temp: 32.5, want: 36},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &controller{
+				Interpolation: tt.interpolation,
+				Targets: map[string]map[float64]uint8{
+					"t1": {10: 0, 30: 30, 40: 70, 60: 100},
+				},
+			}
+			got := c.getNeededDutyCycles(tt.temp)
+			require.Equal(t, tt.want, got["t1"].dutyCycle)
+		})
+	}
+}
+
+func Test_controller_hysteresisDown(t *testing.T) {
+	sortedTemps := []float64{0, 10, 20, 30}
+
+	tests := []struct {
+		name string
+		temp float64
+		want map[string]*targetData
+	}{
+		{name: "a", temp: 5, want: map[string]*targetData{"t1": {dutyCycle: 0, lastUpdatedTemp: 0, sortedMappingTemps: sortedTemps}}},
+		{name: "up on point", temp: 10, want: map[string]*targetData{"t1": {dutyCycle: 10, lastUpdatedTemp: 10, sortedMappingTemps: sortedTemps}}},
+		{name: "hold just below point", temp: 9, want: map[string]*targetData{"t1": {dutyCycle: 10, lastUpdatedTemp: 10, sortedMappingTemps: sortedTemps}}},
+		{name: "hold at hysteresis", temp: 7, want: map[string]*targetData{"t1": {dutyCycle: 10, lastUpdatedTemp: 10, sortedMappingTemps: sortedTemps}}},
+		{name: "down past hysteresis", temp: 6.5, want: map[string]*targetData{"t1": {dutyCycle: 0, lastUpdatedTemp: 6.5, sortedMappingTemps: sortedTemps}}},
+		{name: "up skipping points", temp: 31, want: map[string]*targetData{"t1": {dutyCycle: 30, lastUpdatedTemp: 31, sortedMappingTemps: sortedTemps}}},
+		{name: "hold on small drop", temp: 28, want: map[string]*targetData{"t1": {dutyCycle: 30, lastUpdatedTemp: 31, sortedMappingTemps: sortedTemps}}},
+		{name: "down one step", temp: 26, want: map[string]*targetData{"t1": {dutyCycle: 20, lastUpdatedTemp: 26, sortedMappingTemps: sortedTemps}}},
+		{name: "down to first point", temp: 2, want: map[string]*targetData{"t1": {dutyCycle: 0, lastUpdatedTemp: 2, sortedMappingTemps: sortedTemps}}},
+	}
+
+	c := &controller{
+		MinTempChange:  20,
+		HysteresisDown: 3,
+		Targets: map[string]map[float64]uint8{
+			"t1": {0: 0, 10: 10, 20: 20, 30: 30},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := c.getNeededDutyCycles(tt.temp)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func dutyCycles(targetsData map[string]*targetData) map[string]uint8 {
+	dcs := make(map[string]uint8)
+	for target, td := range targetsData {
+		dcs[target] = td.dutyCycle
+	}
+	return dcs
+}
+
+func Test_controller_onTempError(t *testing.T) {
+	newController := func(policy errorPolicy) *controller {
+		c := &controller{
+			OnError:        policy,
+			FailsafeDuty:   70,
+			MaxAfterErrors: 3,
+			Targets: map[string]map[float64]uint8{
+				"t1": {0: 20, 40: 40},
+				"t2": {0: 30},
+			},
+		}
+		c.getNeededDutyCycles(45)
+		return c
+	}
+
+	hold := map[string]uint8{"t1": 40, "t2": 30}
+
+	c := newController(OnErrorHold)
+	require.Equal(t, hold, dutyCycles(c.onTempError()))
+	require.Equal(t, hold, dutyCycles(c.onTempError()))
+	require.Equal(t, 2, c.onTempRecovered())
+	require.Equal(t, 0, c.onTempRecovered())
+
+	c = newController(OnErrorFailsafe)
+	require.Equal(t, map[string]uint8{"t1": 70, "t2": 70}, dutyCycles(c.onTempError()))
+	c.Temp = tempSource{Method: "cli", Arg: "echo 40"}
+	require.NoError(t, c.validate())
+	c.FailsafeDuty = 0
+	require.Error(t, c.validate())
+
+	c = newController(OnErrorMax)
+	require.Equal(t, hold, dutyCycles(c.onTempError()))
+	require.Equal(t, hold, dutyCycles(c.onTempError()))
+	require.Equal(t, map[string]uint8{"t1": 100, "t2": 100}, dutyCycles(c.onTempError()))
+	require.Equal(t, 3, c.onTempRecovered())
+	require.Equal(t, hold, dutyCycles(c.onTempError()))
+}
+
+func Test_controller_onTempError_fresh(t *testing.T) {
+	newController := func(policy errorPolicy) *controller {
+		return &controller{
+			OnError:        policy,
+			FailsafeDuty:   70,
+			MaxAfterErrors: 2,
+			Targets: map[string]map[float64]uint8{
+				"t1": {0: 20, 40: 40},
+			},
+			RPMTargets: map[string]map[float64]uint16{
+				"pump": {0: 1800},
+			},
+		}
+	}
+
+	// the source fails before any successful reading, the targets are left alone
+	c := newController(OnErrorHold)
+	require.Empty(t, c.onTempError())
+
+	c = newController(OnErrorMax)
+	require.Empty(t, c.onTempError())
+	require.Equal(t, map[string]uint8{"t1": 100, "pump": 100}, dutyCycles(c.onTempError()))
+
+	c = newController(OnErrorFailsafe)
+	require.Equal(t, map[string]uint8{"t1": 70, "pump": 70}, dutyCycles(c.onTempError()))
+}
+
+func Test_controller_rpmTargets(t *testing.T) {
+	c := &controller{
+		Interpolation: InterpolationLinear,
+		Targets: map[string]map[float64]uint8{
+			"side": {30: 20, 50: 100},
+		},
+		RPMTargets: map[string]map[float64]uint16{
+			"pump": {30: 1800, 50: 4200},
+		},
+	}
+
+	got := c.getNeededDutyCycles(40)
+	require.Equal(t, uint8(60), got["side"].dutyCycle)
+	require.False(t, got["side"].useRPM)
+	require.Equal(t, uint16(3000), got["pump"].rpm)
+	require.True(t, got["pump"].useRPM)
+
+	fixed := c.fixedDutyCycles(100)
+	require.Equal(t, uint8(100), fixed["pump"].dutyCycle)
+	require.False(t, fixed["pump"].useRPM)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/oblq/tmi/modules/commanderpro"
+	"github.com/oblq/tmi/modules/ipmi"
+)
+
+type discoveredSensor struct {
+	name    string
+	method  string
+	arg     string
+	reading string
+}
+
+type discoveredFan struct {
+	name   string
+	target string
+	note   string
+}
+
+// discover enumerate the ipmi temp sensors and the commanderpro
+// sensors and fans, then print a targets_map and controllers
+// skeleton for tmi.yaml.
+// Unavailable modules are reported as comments.
+func discover(configPath string, w io.Writer) {
+	sensors, fans, notes := discoverIPMI(configPath)
+
+	if cp, err := commanderpro.Open(); err != nil {
+		notes = append(notes, "commanderpro: "+err.Error())
+	} else {
+		defer cp.Close()
+
+		if connected, err := cp.GetConnectedSensors(); err != nil {
+			notes = append(notes, "commanderpro sensors: "+err.Error())
+		} else {
+			for i, ok := range connected {
+				if !ok {
+					continue
+				}
+				reading := "no reading"
+				if temp, err := cp.GetTempForSensor(commanderpro.TempSensor(i)); err == nil {
+					reading = fmt.Sprintf("%.1f degrees C", temp)
+				}
+				sensors = append(sensors, discoveredSensor{
+					name:    fmt.Sprintf("commanderpro sensor %d", i+1),
+					method:  "commanderpro",
+					arg:     fmt.Sprintf("0x%02x", i),
+					reading: reading,
+				})
+			}
+		}
+
+		if f1, f2, f3, f4, f5, f6, err := cp.GetFanMask(); err != nil {
+			notes = append(notes, "commanderpro fans: "+err.Error())
+		} else {
+			for ch, mode := range []commanderpro.FanMode{f1, f2, f3, f4, f5, f6} {
+				if mode != commanderpro.FanMode3Pin && mode != commanderpro.FanMode4Pin {
+					continue
+				}
+				note := "3pin"
+				if mode == commanderpro.FanMode4Pin {
+					note = "4pin"
+				}
+				if rpm, err := cp.GetChannelRPM(uint8(ch)); err == nil {
+					note += fmt.Sprintf(", %d rpm", rpm)
+				}
+				fans = append(fans, discoveredFan{
+					name:   fmt.Sprintf("fan%d", ch+1),
+					target: fmt.Sprintf("commanderpro.%d", ch),
+					note:   note,
+				})
+			}
+		}
+	}
+
+	renderSkeleton(w, sensors, fans, notes)
+}
+
+// discoverIPMI enumerate the ipmi temp sensors
+// and the fan zones of the ipmi vendor profile.
+func discoverIPMI(configPath string) (sensors []discoveredSensor, fans []discoveredFan, notes []string) {
+	sensors = make([]discoveredSensor, 0)
+	fans = make([]discoveredFan, 0)
+	notes = make([]string, 0)
+
+	ipmiInterface, err := ipmi.New()
+	if err != nil {
+		notes = append(notes, "ipmi: "+err.Error())
+		return
+	}
+	defer ipmiInterface.Close()
+
+	if err := ipmiInterface.ReadConfig(configPath); err != nil {
+		notes = append(notes, "ipmi: unable to read ipmi.yaml: "+err.Error())
+		return
+	}
+
+	records, err := ipmiInterface.TempSensors()
+	if err != nil {
+		notes = append(notes, "ipmi: "+err.Error())
+		return
+	}
+	// by name, an entity can have several temperature sensors
+	for _, r := range records {
+		sensors = append(sensors, discoveredSensor{name: r.Name, method: "ipmi", arg: r.Name, reading: r.Reading})
+	}
+
+	zones, err := ipmiInterface.Zones()
+	if err != nil {
+		notes = append(notes, "ipmi zones: "+err.Error())
+		return
+	}
+	for _, zone := range zones {
+		note := "duty-cycle unknown"
+		if dc, err := ipmiInterface.GetChannelDutyCycle(zone); err == nil {
+			note = fmt.Sprintf("%d%%", dc)
+		}
+		fans = append(fans, discoveredFan{
+			name:   fmt.Sprintf("ipmi_zone%d", zone),
+			target: fmt.Sprintf("ipmi.%d", zone),
+			note:   note,
+		})
+	}
+	return
+}
+
+// renderSkeleton print a tmi.yaml targets_map and controllers skeleton,
+// with a controller per sensor driving all the fans.
+func renderSkeleton(w io.Writer, sensors []discoveredSensor, fans []discoveredFan, notes []string) {
+	for _, note := range notes {
+		fmt.Fprintf(w, "# %s\n", note)
+	}
+	if len(notes) > 0 {
+		fmt.Fprintln(w)
+	}
+
+	fmt.Fprintln(w, "targets_map:")
+	for _, f := range fans {
+		fmt.Fprintf(w, "  %s: %s # %s\n", f.name, f.target, f.note)
+	}
+
+	fmt.Fprintln(w, "\ncontrollers:")
+	for _, s := range sensors {
+		fmt.Fprintf(w, "\n  # %s\n", s.reading)
+		fmt.Fprintf(w, "  - name: %s\n", s.name)
+		fmt.Fprintln(w, "    min_temp_change: 2")
+		fmt.Fprintln(w, "    temp:")
+		fmt.Fprintf(w, "      method: %s\n", s.method)
+		fmt.Fprintf(w, "      arg: %s\n", s.arg)
+		fmt.Fprintln(w, "    targets:")
+		for _, f := range fans {
+			fmt.Fprintf(w, "      %s:\n        0: 30\n        50: 60\n        70: 100\n", f.name)
+		}
+	}
+}
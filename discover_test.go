@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_renderSkeleton(t *testing.T) {
+	sensors := []discoveredSensor{
+		{name: "CPU Temp", method: "ipmi", arg: "CPU Temp", reading: "45 degrees C"},
+	}
+	fans := []discoveredFan{
+		{name: "ipmi_zone0", target: "ipmi.0", note: "50%"},
+		{name: "fan1", target: "commanderpro.0", note: "4pin, 1200 rpm"},
+	}
+
+	var buf bytes.Buffer
+	renderSkeleton(&buf, sensors, fans, []string{"commanderpro: could not open a device"})
+
+	require.Equal(t, `# commanderpro: could not open a device
+
+targets_map:
+  ipmi_zone0: ipmi.0 # 50%
+  fan1: commanderpro.0 # 4pin, 1200 rpm
+
+controllers:
+
+  # 45 degrees C
+  - name: CPU Temp
+    min_temp_change: 2
+    temp:
+      method: ipmi
+      arg: CPU Temp
+    targets:
+      ipmi_zone0:
+        0: 30
+        50: 60
+        70: 100
+      fan1:
+        0: 30
+        50: 60
+        70: 100
+`, buf.String())
+}
+
+// fakeIpmitool answers like ipmitool on a Supermicro X11 board,
+// with captured `sdr elist full` output and zone duty-cycles of 50% and 100%.
+const fakeIpmitool = `#!/bin/sh
+case "$*" in
+"sdr elist"*)
+	cat <<'EOF'
+CPU Temp         | 01h | ok  |  3.1 | 45 degrees C
+CPU VRM Temp     | 08h | ok  |  3.1 | 52 degrees C
+PCH Temp         | 0Ah | ok  |  7.1 | 48 degrees C
+System Temp      | 0Bh | ok  |  7.2 | 31 degrees C
+VcpuVRM Temp     | 10h | ns  |  8.1 | No Reading
+FAN1             | 41h | ok  | 29.1 | 1400 RPM
+12V              | 30h | ok  |  7.17 | 12.06 Volts
+EOF
+	;;
+"raw 0x30 0x70 0x66 0x00 0x00") echo " 32" ;;
+"raw 0x30 0x70 0x66 0x00 0x01") echo " 64" ;;
+*) echo "unexpected: $*" >&2; exit 1 ;;
+esac
+`
+
+func Test_discoverIPMI(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tmi")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ipmitool := filepath.Join(dir, "ipmitool")
+	require.NoError(t, ioutil.WriteFile(ipmitool, []byte(fakeIpmitool), 0755))
+	config := "cmd: " + ipmitool + "\nvendor: supermicro_x11\n"
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "ipmi.yaml"), []byte(config), 0644))
+
+	sensors, fans, notes := discoverIPMI(dir)
+	require.Empty(t, notes)
+	require.Equal(t, []discoveredSensor{
+		{name: "CPU Temp", method: "ipmi", arg: "CPU Temp", reading: "45 degrees C"},
+		{name: "CPU VRM Temp", method: "ipmi", arg: "CPU VRM Temp", reading: "52 degrees C"},
+		{name: "PCH Temp", method: "ipmi", arg: "PCH Temp", reading: "48 degrees C"},
+		{name: "System Temp", method: "ipmi", arg: "System Temp", reading: "31 degrees C"},
+	}, sensors)
+	require.Equal(t, []discoveredFan{
+		{name: "ipmi_zone0", target: "ipmi.0", note: "50%"},
+		{name: "ipmi_zone1", target: "ipmi.1", note: "100%"},
+	}, fans)
+
+	_, _, notes = discoverIPMI(filepath.Join(dir, "nope"))
+	require.Len(t, notes, 1)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// emergency force every target to 100% when a controller
+// temperature exceeds its critical value.
+// The override latches until every temperature drops
+// Release °C below its critical value.
+type emergency struct {
+	// Critical is the critical temperature per controller name.
+	Critical map[string]float64 `yaml:"critical"`
+
+	// Release is how many °C below its critical value
+	// every temperature must drop to release the override.
+	Release float64 `yaml:"release"`
+
+	// Command is an optional shell command executed
+	// when the override is triggered, see runHook.
+	Command string `yaml:"command"`
+}
+
+// validate check that the critical temps refer to the controllers.
+func (e *emergency) validate(controllers []*controller) error {
+	if e.Release < 0 {
+		return errors.New("emergency release must not be negative")
+	}
+
+	names := make(map[string]bool, len(controllers))
+	for _, c := range controllers {
+		names[c.Name] = true
+	}
+	for name := range e.Critical {
+		if !names[name] {
+			return fmt.Errorf("emergency critical: no such controller: %s", name)
+		}
+	}
+	return nil
+}
+
+// update check the temps by controller name, returns the override state
+// given the current one, so that it survives the config reloads.
+// A missing temp never releases the override.
+func (e *emergency) update(active bool, temps map[string]float64) bool {
+	if !active {
+		for name, critical := range e.Critical {
+			if temp, ok := temps[name]; ok && temp > critical {
+				return true
+			}
+		}
+		return false
+	}
+
+	for name, critical := range e.Critical {
+		if temp, ok := temps[name]; !ok || temp >= critical-e.Release {
+			return true
+		}
+	}
+	return false
+}
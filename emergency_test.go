@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_emergency_update(t *testing.T) {
+	e := &emergency{
+		Critical: map[string]float64{"CPU": 90, "GPU": 85},
+		Release:  10,
+	}
+
+	tests := []struct {
+		name        string
+		temps       map[string]float64
+		wantChanged bool
+		wantActive  bool
+	}{
+		{name: "normal", temps: map[string]float64{"CPU": 60, "GPU": 60}},
+		{name: "on critical", temps: map[string]float64{"CPU": 90, "GPU": 60}},
+		{name: "over critical", temps: map[string]float64{"CPU": 60, "GPU": 86}, wantChanged: true, wantActive: true},
+		{name: "latched below critical", temps: map[string]float64{"CPU": 60, "GPU": 80}, wantActive: true},
+		{name: "latched on missing temp", temps: map[string]float64{"CPU": 60}, wantActive: true},
+		{name: "latched on release", temps: map[string]float64{"CPU": 60, "GPU": 75}, wantActive: true},
+		{name: "released", temps: map[string]float64{"CPU": 60, "GPU": 74}, wantChanged: true},
+		{name: "missing temp never triggers", temps: map[string]float64{}},
+	}
+
+	var active bool
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := e.update(active, tt.temps)
+			require.Equal(t, tt.wantChanged, next != active)
+			require.Equal(t, tt.wantActive, next)
+			active = next
+		})
+	}
+}
+
+func Test_emergency_validate(t *testing.T) {
+	controllers := []*controller{{Name: "CPU"}}
+
+	e := &emergency{Critical: map[string]float64{"CPU": 90}, Release: 10}
+	require.NoError(t, e.validate(controllers))
+
+	e.Critical["CUP"] = 90
+	require.Error(t, e.validate(controllers), "typo")
+
+	e = &emergency{Critical: map[string]float64{"CPU": 90}, Release: -1}
+	require.Error(t, e.validate(controllers))
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// filterType is the smoothing method applied to temp readings.
+type filterType string
+
+const (
+	// FilterMovingAverage is the average of the last Samples readings.
+	FilterMovingAverage filterType = "moving_average"
+	// FilterEMA is the exponential moving average with Alpha smoothing factor.
+	FilterEMA filterType = "ema"
+	// FilterMedian is the median of the last Samples readings.
+	FilterMedian filterType = "median"
+)
+
+// filter smooths the temp readings of a controller,
+// its state is kept across ticks and reset on config reload.
+type filter struct {
+	Type filterType `yaml:"type"`
+
+	// Samples is the window size for moving_average and median.
+	Samples int `yaml:"samples"`
+
+	// Alpha is the ema smoothing factor in the (0, 1] range,
+	// lower values give smoother readings.
+	Alpha float64 `yaml:"alpha"`
+
+	// runtime vars -----------------------------------
+
+	window []float64
+	ema    float64
+	primed bool
+}
+
+func (f *filter) validate() error {
+	switch f.Type {
+	case FilterMovingAverage, FilterMedian:
+		if f.Samples < 1 {
+			return fmt.Errorf("filter %s needs samples >= 1", f.Type)
+		}
+	case FilterEMA:
+		if f.Alpha <= 0 || f.Alpha > 1 {
+			return fmt.Errorf("filter %s needs alpha in the (0, 1] range", f.Type)
+		}
+	default:
+		return fmt.Errorf("unknown filter type `%s`, use moving_average, ema or median", f.Type)
+	}
+	return nil
+}
+
+// apply add a reading to the filter and return the filtered temp,
+// a nil filter return the reading as is.
+func (f *filter) apply(temp float64) float64 {
+	if f == nil {
+		return temp
+	}
+
+	if f.Type == FilterEMA {
+		if !f.primed {
+			f.primed = true
+			f.ema = temp
+		}
+		f.ema = f.Alpha*temp + (1-f.Alpha)*f.ema
+		return f.ema
+	}
+
+	f.window = append(f.window, temp)
+	if len(f.window) > f.Samples {
+		f.window = f.window[len(f.window)-f.Samples:]
+	}
+
+	if f.Type == FilterMedian {
+		sorted := append([]float64(nil), f.window...)
+		sort.Float64s(sorted)
+		mid := len(sorted) / 2
+		if len(sorted)%2 == 0 {
+			return (sorted[mid-1] + sorted[mid]) / 2
+		}
+		return sorted[mid]
+	}
+
+	var sum float64
+	for _, t := range f.window {
+		sum += t
+	}
+	return sum / float64(len(f.window))
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_filter_apply(t *testing.T) {
+	readings := []float64{40, 42, 60, 44, 46}
+
+	tests := []struct {
+		name   string
+		filter *filter
+		want   []float64
+	}{
+		{name: "nil", filter: nil, want: []float64{40, 42, 60, 44, 46}},
+		{name: "moving_average", filter: &filter{Type: FilterMovingAverage, Samples: 3}, want: []float64{40, 41, 142.0 / 3, 146.0 / 3, 50}},
+		{name: "median", filter: &filter{Type: FilterMedian, Samples: 3}, want: []float64{40, 41, 42, 44, 46}},
+		{name: "ema", filter: &filter{Type: FilterEMA, Alpha: 0.5}, want: []float64{40, 41, 50.5, 47.25, 46.625}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i, temp := range readings {
+				require.InDelta(t, tt.want[i], tt.filter.apply(temp), 0.0001, "reading %d", i)
+			}
+		})
+	}
+}
@@ -11,6 +11,11 @@ import (
 var Path = "/home/marco/go/src/github.com/oblq/tmi/artifacts/"
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "discover" {
+		discover(Path, os.Stdout)
+		return
+	}
+
 	stopCh := make(chan os.Signal, 1)
 	signal.Notify(stopCh, syscall.SIGINT, syscall.SIGTERM)
 
@@ -32,8 +37,5 @@ func main() {
 	<-done
 	fmt.Println("exiting")
 
-	cm.StopMonitoring()
-	for _, c := range cm.closers {
-		c.Close()
-	}
+	cm.Close()
 }
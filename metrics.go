@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// methodMetric is the tempExtractor name of the modules metrics.
+const methodMetric = "metric"
+
+// metrics is a tempExtractor reading the metricReader modules,
+// so that a controller can be driven by any metric (eg.: a voltage)
+// with `method: metric` and `arg: <module>.<metric>`.
+// It reads a snapshot of the modules, updated on reload, so that
+// it can be used outside the manager lock (eg.: commanderpro external temps).
+type metrics struct {
+	mutex   sync.RWMutex
+	readers map[string]metricReader
+}
+
+// update replace the snapshot with the reloaded modules.
+func (m *metrics) update(metricReaders map[string]metricReader) {
+	readers := make(map[string]metricReader, len(metricReaders))
+	for name, mr := range metricReaders {
+		readers[name] = mr
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.readers = readers
+}
+
+// module interface implementation
+func (m *metrics) Name() string {
+	return methodMetric
+}
+
+// tempExtractor interface implementation
+func (m *metrics) GetTemp(arg string) (value float64, err error) {
+	return m.get(arg)
+}
+
+// get read a `<module>.<metric>` metric.
+func (m *metrics) get(arg string) (value float64, err error) {
+	moduleMetric := strings.SplitN(arg, ".", 2)
+	if len(moduleMetric) < 2 {
+		return 0, fmt.Errorf("metric must be a string containing the module and the metric separated by a dot. eg.: `commanderpro.12v`, got: %s", arg)
+	}
+
+	m.mutex.RLock()
+	mr, ok := m.readers[moduleMetric[0]]
+	m.mutex.RUnlock()
+	if !ok {
+		return 0, fmt.Errorf("no such metric module: %s", moduleMetric[0])
+	}
+	return mr.GetMetric(moduleMetric[1])
+}
+
+// alert log a message and run an optional hook command
+// when a metric goes out of the Min-Max range.
+// The alert is raised again only after the metric is back in range.
+type alert struct {
+	Name string `yaml:"name"`
+
+	// Metric is the `<module>.<metric>` to watch.
+	Metric string `yaml:"metric"`
+
+	// Min and Max are the metric range,
+	// a nil value means no limit.
+	Min *float64 `yaml:"min"`
+	Max *float64 `yaml:"max"`
+
+	// Command is an optional shell command executed
+	// through the cli module when the alert is raised.
+	Command string `yaml:"command"`
+
+	// runtime vars -----------------------------------
+
+	active bool
+}
+
+// update check the metric value,
+// returns true if the alert state has changed.
+func (a *alert) update(value float64) (changed bool) {
+	outOfRange := (a.Min != nil && value < *a.Min) || (a.Max != nil && value > *a.Max)
+	changed = outOfRange != a.active
+	a.active = outOfRange
+	return
+}
+
+// checkAlerts read the metrics of the alerts and raise them if needed.
+func (cm *ControlManager) checkAlerts() {
+	for _, a := range cm.Alerts {
+		value, err := cm.metrics.get(a.Metric)
+		if err != nil {
+			fmt.Println("unable to read metric for alert", a.Name, "->", err.Error())
+			continue
+		}
+
+		if a.update(value) {
+			if a.active {
+				fmt.Printf("ALERT: %s, %s is %.3f\n", a.Name, a.Metric, value)
+				go runHook("alert", a.Command)
+			} else {
+				fmt.Printf("alert %s released, %s is %.3f\n", a.Name, a.Metric, value)
+			}
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_alert_update(t *testing.T) {
+	min, max := 11.6, 12.6
+	a := &alert{Name: "12V rail", Metric: "commanderpro.12v", Min: &min, Max: &max}
+
+	tests := []struct {
+		name        string
+		value       float64
+		wantChanged bool
+		wantActive  bool
+	}{
+		{name: "in range", value: 12.1},
+		{name: "sagging", value: 11.4, wantChanged: true, wantActive: true},
+		{name: "still sagging", value: 11.5, wantActive: true},
+		{name: "back in range", value: 11.9, wantChanged: true},
+		{name: "over max", value: 12.7, wantChanged: true, wantActive: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.wantChanged, a.update(tt.value))
+			require.Equal(t, tt.wantActive, a.active)
+		})
+	}
+}
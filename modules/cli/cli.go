@@ -2,16 +2,49 @@ package cli
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"os/exec"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
-type Cli struct{}
+// DefaultTimeout is the timeout of Command and CommandPipe,
+// shorter than the default check_interval (6s).
+const DefaultTimeout = 5 * time.Second
+
+// ErrTimeout is returned (wrapped) when a command is killed
+// because its context expired, check it with errors.Is.
+var ErrTimeout = errors.New("command timed out")
+
+type Cli struct {
+	mutex sync.Mutex
+
+	// timeout is the GetTemp commands timeout,
+	// DefaultTimeout if zero.
+	timeout time.Duration
+}
+
+// SetTimeout set the GetTemp commands timeout,
+// DefaultTimeout if zero.
+func (cli *Cli) SetTimeout(timeout time.Duration) {
+	cli.mutex.Lock()
+	defer cli.mutex.Unlock()
+	cli.timeout = timeout
+}
 
 func Command(cmdString string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+	defer cancel()
+	return CommandContext(ctx, cmdString)
+}
+
+// CommandContext is like Command, the command process group
+// is killed if ctx expires before the command completes.
+func CommandContext(ctx context.Context, cmdString string) (string, error) {
 	nameCmd := strings.SplitN(cmdString, " ", 2)
 	if len(nameCmd) != 2 {
 		return "", errors.New("wrong cmd: " + cmdString)
@@ -20,33 +53,57 @@ func Command(cmdString string) (string, error) {
 	name := nameCmd[0]
 	arg := strings.Fields(nameCmd[1])
 
-	cmd := exec.Command(name, arg...)
-
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
-
-	var stout bytes.Buffer
-	cmd.Stdout = &stout
-
-	err := cmd.Run()
-	if err != nil {
-		return "", fmt.Errorf("%v: %s", err, stderr.String())
-	}
-
-	out := strings.TrimSuffix(stout.String(), "\n")
-	return out, nil
+	return run(ctx, exec.Command(name, arg...))
 }
 
 func CommandPipe(cmdString string) (string, error) {
-	cmd := exec.Command("bash", "-c", cmdString)
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+	defer cancel()
+	return CommandPipeContext(ctx, cmdString)
+}
 
+// CommandPipeContext is like CommandPipe, the command process group
+// is killed if ctx expires before the command completes.
+func CommandPipeContext(ctx context.Context, cmdString string) (string, error) {
+	return run(ctx, exec.Command("bash", "-c", cmdString))
+}
+
+// run the command killing its process group if ctx expires,
+// so that the children of `bash -c` are killed too.
+func run(ctx context.Context, cmd *exec.Cmd) (string, error) {
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
 
 	var stout bytes.Buffer
 	cmd.Stdout = &stout
 
-	err := cmd.Run()
+	setProcessGroup(cmd)
+
+	if err := cmd.Start(); err != nil {
+		return "", err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+
+	timedOut := make(chan bool, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			killProcessGroup(cmd)
+			timedOut <- true
+		case <-done:
+			timedOut <- false
+		}
+	}()
+
+	err := cmd.Wait()
+	if ctx.Err() != nil {
+		// wait for the kill to be completed
+		if <-timedOut {
+			return "", fmt.Errorf("%w: `%s` %v", ErrTimeout, strings.Join(cmd.Args, " "), ctx.Err())
+		}
+	}
 	if err != nil {
 		return "", fmt.Errorf("%v: %s", err, stderr.String())
 	}
@@ -58,14 +115,23 @@ func CommandPipe(cmdString string) (string, error) {
 // ---------------------------------------------------------------------------------------------------------------------
 
 // module interface implementation
-func (cli Cli) Name() string {
+func (cli *Cli) Name() string {
 	return "cli"
 }
 
 // tempExtractor interface implementation
-func (cli Cli) GetTemp(cmd string) (temp float64, err error) {
+func (cli *Cli) GetTemp(cmd string) (temp float64, err error) {
+	cli.mutex.Lock()
+	timeout := cli.timeout
+	cli.mutex.Unlock()
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
 	var tString string
-	tString, err = CommandPipe(cmd)
+	tString, err = CommandPipeContext(ctx, cmd)
 	if err != nil {
 		return
 	}
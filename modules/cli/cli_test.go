@@ -0,0 +1,43 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommandPipeContext(t *testing.T) {
+	out, err := CommandPipeContext(context.Background(), "echo 42 | tr 4 5")
+	require.NoError(t, err)
+	require.Equal(t, "52", out)
+
+	_, err = CommandPipeContext(context.Background(), "exit 1")
+	require.Error(t, err)
+	require.False(t, errors.Is(err, ErrTimeout))
+}
+
+func TestCommandPipeContext_timeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	// the background sleep holds stdout open,
+	// the command returns only if the whole process group is killed.
+	start := time.Now()
+	_, err := CommandPipeContext(ctx, "sleep 5 & sleep 5")
+	require.True(t, errors.Is(err, ErrTimeout), err)
+	require.True(t, time.Since(start) < 2*time.Second, "process group not killed")
+}
+
+func TestCli_GetTemp_timeout(t *testing.T) {
+	c := &Cli{}
+	c.SetTimeout(100 * time.Millisecond)
+	_, err := c.GetTemp("sleep 5; echo 42")
+	require.True(t, errors.Is(err, ErrTimeout), err)
+
+	temp, err := (&Cli{}).GetTemp("echo 42.5")
+	require.NoError(t, err)
+	require.Equal(t, 42.5, temp)
+}
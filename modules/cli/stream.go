@@ -0,0 +1,292 @@
+package cli
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultStreamMaxAge is the default maximum age of a streamed value.
+	DefaultStreamMaxAge = 10 * time.Second
+
+	streamMinBackoff    = time.Second
+	streamMaxBackoff    = time.Minute
+	streamWatchInterval = 250 * time.Millisecond
+)
+
+// LineStream run a long-lived command passing every non-empty
+// output line to a callback. The command is restarted with an
+// exponential backoff if it exits, and killed then restarted
+// if it prints nothing for longer than the stall timeout.
+type LineStream struct {
+	mutex sync.Mutex
+
+	name   string
+	newCmd func() *exec.Cmd
+	onLine func(line string)
+
+	stallTimeout time.Duration
+	lastLine     time.Time
+	process      *exec.Cmd
+	stopped      bool
+}
+
+// NewLineStream return a new LineStream, newCmd is called on every (re)start.
+// A zero stallTimeout disables the stall detection.
+func NewLineStream(name string, newCmd func() *exec.Cmd, stallTimeout time.Duration, onLine func(line string)) *LineStream {
+	return &LineStream{name: name, newCmd: newCmd, stallTimeout: stallTimeout, onLine: onLine}
+}
+
+// Start run the command in background until stopped.
+func (ls *LineStream) Start() {
+	go ls.run()
+}
+
+// SetStallTimeout update the stall timeout of the running command.
+func (ls *LineStream) SetStallTimeout(stallTimeout time.Duration) {
+	ls.mutex.Lock()
+	ls.stallTimeout = stallTimeout
+	ls.mutex.Unlock()
+}
+
+// Stop kill the command, it is not restarted.
+func (ls *LineStream) Stop() {
+	ls.mutex.Lock()
+	defer ls.mutex.Unlock()
+
+	ls.stopped = true
+	if ls.process != nil {
+		killProcessGroup(ls.process)
+	}
+}
+
+func (ls *LineStream) isStopped() bool {
+	ls.mutex.Lock()
+	defer ls.mutex.Unlock()
+	return ls.stopped
+}
+
+func (ls *LineStream) touch() {
+	ls.mutex.Lock()
+	ls.lastLine = time.Now()
+	ls.mutex.Unlock()
+}
+
+// watch kill cmd if it stalls, until done is closed.
+func (ls *LineStream) watch(cmd *exec.Cmd, done chan struct{}) {
+	ticker := time.NewTicker(streamWatchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			ls.mutex.Lock()
+			silence := time.Since(ls.lastLine)
+			stalled := ls.stallTimeout > 0 && silence > ls.stallTimeout
+			ls.mutex.Unlock()
+
+			if stalled {
+				fmt.Printf("%s printed nothing for %s, killing it\n", ls.name, silence.Round(time.Second))
+				killProcessGroup(cmd)
+				return
+			}
+		}
+	}
+}
+
+// runOnce start the command and read its output until it exits,
+// returns true if at least one line has been read.
+func (ls *LineStream) runOnce() (gotOutput bool, err error) {
+	cmd := ls.newCmd()
+	setProcessGroup(cmd)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return false, err
+	}
+
+	ls.mutex.Lock()
+	if ls.stopped {
+		ls.mutex.Unlock()
+		return false, errors.New("stopped")
+	}
+	if err = cmd.Start(); err != nil {
+		ls.mutex.Unlock()
+		return false, err
+	}
+	ls.process = cmd
+	ls.lastLine = time.Now()
+	ls.mutex.Unlock()
+
+	done := make(chan struct{})
+	defer close(done)
+	go ls.watch(cmd, done)
+
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			ls.touch()
+			ls.onLine(line)
+			gotOutput = true
+		}
+	}
+	return gotOutput, cmd.Wait()
+}
+
+// run the command restarting it with backoff until stopped.
+func (ls *LineStream) run() {
+	backoff := streamMinBackoff
+	for {
+		gotOutput, err := ls.runOnce()
+		if ls.isStopped() {
+			return
+		}
+
+		if gotOutput {
+			backoff = streamMinBackoff
+		}
+		fmt.Printf("%s exited (%v), restarting in %s\n", ls.name, err, backoff)
+		time.Sleep(backoff)
+
+		if backoff *= 2; backoff > streamMaxBackoff {
+			backoff = streamMaxBackoff
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// Stream is a tempExtractor for long-lived commands printing
+// newline-delimited values, eg.: `nvidia-smi ... -l 1`.
+// Every command is started once and its latest value is served,
+// it is restarted with an exponential backoff if it exits
+// or if it prints nothing for longer than the max age.
+type Stream struct {
+	mutex sync.Mutex
+
+	// maxAge is the maximum age of a value,
+	// older values are reported as errors.
+	maxAge time.Duration
+
+	// <command> : stream
+	streams map[string]*stream
+}
+
+// NewStream return a new Stream instance.
+func NewStream() *Stream {
+	return &Stream{maxAge: DefaultStreamMaxAge, streams: make(map[string]*stream)}
+}
+
+// SetMaxAge set the maximum age of the values,
+// it is also the stall timeout of the commands.
+func (st *Stream) SetMaxAge(maxAge time.Duration) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	st.maxAge = maxAge
+	for _, s := range st.streams {
+		s.process.SetStallTimeout(maxAge)
+	}
+}
+
+// Retain stop the commands not in commands,
+// a stopped command is started again on its next reading.
+func (st *Stream) Retain(commands []string) {
+	retain := make(map[string]bool, len(commands))
+	for _, command := range commands {
+		retain[command] = true
+	}
+
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	for command, s := range st.streams {
+		if !retain[command] {
+			s.process.Stop()
+			delete(st.streams, command)
+		}
+	}
+}
+
+type stream struct {
+	mutex sync.Mutex
+
+	command string
+	process *LineStream
+
+	value float64
+	err   error
+	time  time.Time
+}
+
+func newStream(command string, maxAge time.Duration) *stream {
+	s := &stream{command: command}
+	s.process = NewLineStream(fmt.Sprintf("cli_stream `%s`", command), func() *exec.Cmd {
+		return exec.Command("bash", "-c", command)
+	}, maxAge, s.set)
+	return s
+}
+
+// set parse and store a line of the command output.
+func (s *stream) set(line string) {
+	value, err := strconv.ParseFloat(strings.Trim(line, " ."), 64)
+	if err != nil {
+		err = fmt.Errorf("cli_stream `%s` returned an invalid value: %s", s.command, line)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err == nil {
+		s.value = value
+	}
+	s.err = err
+	s.time = time.Now()
+}
+
+func (s *stream) latest(maxAge time.Duration) (float64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.time.IsZero() {
+		return 0, fmt.Errorf("cli_stream `%s` has not returned any value yet", s.command)
+	}
+	if age := time.Since(s.time); age > maxAge {
+		return 0, fmt.Errorf("cli_stream `%s` value is stale (%s old)", s.command, age.Round(time.Second))
+	}
+	return s.value, s.err
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// module interface implementation
+func (st *Stream) Name() string {
+	return "cli_stream"
+}
+
+// tempExtractor interface implementation,
+// the command is started on its first reading.
+func (st *Stream) GetTemp(command string) (temp float64, err error) {
+	st.mutex.Lock()
+	s, ok := st.streams[command]
+	if !ok {
+		s = newStream(command, st.maxAge)
+		st.streams[command] = s
+		s.process.Start()
+	}
+	maxAge := st.maxAge
+	st.mutex.Unlock()
+
+	return s.latest(maxAge)
+}
+
+// closer interface implementation, stop all the commands.
+func (st *Stream) Close() {
+	st.Retain(nil)
+}
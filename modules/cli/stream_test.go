@@ -0,0 +1,78 @@
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStream_GetTemp(t *testing.T) {
+	st := NewStream()
+	defer st.Close()
+
+	command := "echo 41; echo 42.5; sleep 1; echo oops; sleep 5"
+
+	_, err := st.GetTemp(command)
+	require.Error(t, err, "no value yet")
+
+	require.Eventually(t, func() bool {
+		temp, err := st.GetTemp(command)
+		return err == nil && temp == 42.5
+	}, time.Second, 10*time.Millisecond)
+
+	require.Eventually(t, func() bool {
+		_, err := st.GetTemp(command)
+		return err != nil
+	}, 2*time.Second, 10*time.Millisecond, "invalid value")
+}
+
+func TestStream_stalled(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stream")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	st := NewStream()
+	st.SetMaxAge(500 * time.Millisecond)
+	defer st.Close()
+
+	// print the run count then hang
+	counter := filepath.Join(dir, "runs")
+	command := "n=$(( $(cat " + counter + " 2>/dev/null || echo 0) + 1 )); echo $n > " + counter + "; echo $n; sleep 30"
+
+	require.Eventually(t, func() bool {
+		temp, err := st.GetTemp(command)
+		return err == nil && temp == 2
+	}, 5*time.Second, 10*time.Millisecond, "restarted after stalling")
+}
+
+func TestStream_Retain(t *testing.T) {
+	st := NewStream()
+	defer st.Close()
+
+	_, _ = st.GetTemp("echo 1; sleep 30")
+	_, _ = st.GetTemp("echo 2; sleep 30")
+	dropped := st.streams["echo 2; sleep 30"]
+
+	st.Retain([]string{"echo 1; sleep 30"})
+
+	require.Len(t, st.streams, 1)
+	require.Contains(t, st.streams, "echo 1; sleep 30")
+	require.True(t, dropped.process.isStopped())
+}
+
+func Test_stream_latest(t *testing.T) {
+	s := &stream{command: "test"}
+	s.set("40.")
+
+	temp, err := s.latest(time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, float64(40), temp)
+
+	s.time = time.Now().Add(-2 * time.Minute)
+	_, err = s.latest(time.Minute)
+	require.Error(t, err, "stale value")
+}
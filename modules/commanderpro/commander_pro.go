@@ -1,6 +1,7 @@
 package commanderpro
 
 import (
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -72,6 +73,22 @@ type Config struct {
 		To      float64
 	} `yaml:"temp_shift_test"`
 
+	// ExternalTempFeed push external temps to the device
+	// external sensor slot of the fan channels.
+	ExternalTempFeed struct {
+		// Interval is the time between two feeds, in seconds, 4 by default.
+		Interval int `yaml:"interval"`
+
+		// Channels are fan channels to be fed with an external_temps entry,
+		// in addition to the external sensor hardware curves.
+		// <fan_channel>: <external_temp>
+		Channels map[uint8]string `yaml:"channels"`
+	} `yaml:"external_temp_feed"`
+
+	// HardwareCurves are the temp/rpm curves programmed into the device,
+	// fans keep following them even if tmi is not running.
+	HardwareCurves map[string]hardwareCurve `yaml:"hardware_curves"`
+
 	LedGroupConfigs map[string]struct {
 		LedCh, LedOffset, LedCount, LedMode, LedSpeed, LedDirection, LedStyle uint8
 		Color1, Color2, Color3                                                Color
@@ -103,7 +120,17 @@ type CommanderPro struct {
 
 	config Config
 
+	// lost is true after an usb communication failure,
+	// the connection is then reopened by CheckConfig.
+	lost bool
+
+	// sensors is the connected sensors mask read at sensorsRead.
+	sensorsMutex sync.Mutex
+	sensors      [4]bool
+	sensorsRead  time.Time
+
 	externalTempTicker *time.Ticker
+	fanTempTicker      *time.Ticker
 	GetExternalTemp    func(method, arg string) (temp float64, err error)
 }
 
@@ -195,6 +222,25 @@ func (cp *CommanderPro) Close() {
 	if cp.intf != nil {
 		cp.intf.Close()
 	}
+	cp.ctx, cp.dev, cp.intf, cp.intfDone = nil, nil, nil, nil
+	cp.inEndpoint, cp.outEndpoint = nil, nil
+}
+
+// reconnect reopen the usb connection after a failure,
+// the config is then reloaded to re-apply the hardware curves and the leds.
+func (cp *CommanderPro) reconnect() error {
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+
+	cp.Close()
+	if err := cp.Open(); err != nil {
+		return err
+	}
+
+	cp.lost = false
+	cp.configStat = nil
+	fmt.Println("commanderpro reconnected")
+	return nil
 }
 
 // LoadConfigThresholds will update ipmi fan thresholds.
@@ -221,6 +267,33 @@ func (cp *CommanderPro) LoadConfig() (err error) {
 		}
 	}
 
+	// external temps to be sent to the fan channels
+	fanTempExtractors := make(map[externalTempExtractor][]uint8)
+
+	for name, curve := range cp.config.HardwareCurves {
+		if err = cp.applyHardwareCurve(curve); err != nil {
+			return fmt.Errorf("error setting hardware curve %s -> %s", name, err.Error())
+		}
+
+		if curve.TempSensor == hardwareCurveExternalSensor {
+			tempExtractor, ok := cp.config.ExternalTempExtractors[curve.ExternalTemp]
+			if !ok {
+				return fmt.Errorf("no such external_temp: %s", curve.ExternalTemp)
+			}
+			fanTempExtractors[tempExtractor] = append(fanTempExtractors[tempExtractor], curve.Channel)
+		}
+	}
+
+	for ch, externalTemp := range cp.config.ExternalTempFeed.Channels {
+		tempExtractor, ok := cp.config.ExternalTempExtractors[externalTemp]
+		if !ok {
+			return fmt.Errorf("no such external_temp: %s", externalTemp)
+		}
+		fanTempExtractors[tempExtractor] = append(fanTempExtractors[tempExtractor], ch)
+	}
+
+	cp.feedFanExternalTemps(fanTempExtractors)
+
 	externalTempExtractors := make(map[externalTempExtractor][]uint8)
 
 	if cp.config.TempShiftTest.Enabled {
@@ -272,6 +345,17 @@ func (cp *CommanderPro) LoadConfig() (err error) {
 }
 
 func (cp *CommanderPro) CheckConfig(configPath string) {
+	cp.mutex.Lock()
+	lost := cp.lost
+	cp.mutex.Unlock()
+
+	if lost {
+		if err := cp.reconnect(); err != nil {
+			fmt.Println("unable to reconnect to Corsair Commander Pro:", err.Error())
+			return
+		}
+	}
+
 	cp.configPath = filepath.Join(configPath, "commanderpro.yaml")
 	if configStat, err := os.Stat(cp.configPath); err != nil {
 		fmt.Println("unable to stat config file:", err.Error())
@@ -290,9 +374,15 @@ func (cp *CommanderPro) cmd(cmd []byte) (response []byte, err error) {
 	cp.mutex.Lock()
 	defer cp.mutex.Unlock()
 
+	// closed, or reopening failed
+	if cp.inEndpoint == nil || cp.outEndpoint == nil {
+		return nil, errors.New("commanderpro not connected")
+	}
+
 	// Write data to the USB device.
 	numBytes, err := cp.outEndpoint.Write(cmd)
 	if numBytes != len(cmd) {
+		cp.lost = err != nil
 		return nil, fmt.Errorf("%s.Write(): only %d bytes written, returned error is %v", cp.outEndpoint, numBytes, err)
 	}
 
@@ -300,6 +390,7 @@ func (cp *CommanderPro) cmd(cmd []byte) (response []byte, err error) {
 	buf := make([]byte, cp.inEndpoint.Desc.MaxPacketSize)
 	readBytes, err := cp.inEndpoint.Read(buf)
 	if err != nil {
+		cp.lost = true
 		return buf, fmt.Errorf("read error: %v", err)
 	}
 	if readBytes == 0 {
@@ -323,10 +414,11 @@ func (cp *CommanderPro) monitorExternalTempIfNeeded(tempExtractors map[externalT
 		return
 	}
 
+	if cp.externalTempTicker != nil {
+		cp.externalTempTicker.Stop()
+	}
+
 	if len(tempExtractors) == 0 {
-		if cp.externalTempTicker != nil {
-			cp.externalTempTicker.Stop()
-		}
 		return
 	}
 
@@ -348,3 +440,43 @@ func (cp *CommanderPro) monitorExternalTempIfNeeded(tempExtractors map[externalT
 		}
 	}()
 }
+
+// feedFanExternalTemps send the external temps to the fan channels
+// using the external sensor, every ExternalTempFeed.Interval seconds.
+func (cp *CommanderPro) feedFanExternalTemps(tempExtractors map[externalTempExtractor][]uint8) {
+	if cp.fanTempTicker != nil {
+		cp.fanTempTicker.Stop()
+	}
+
+	if len(tempExtractors) == 0 {
+		return
+	}
+
+	interval := cp.config.ExternalTempFeed.Interval
+	if interval <= 0 {
+		interval = 4
+	}
+
+	feed := func() {
+		for tExtractor, channels := range tempExtractors {
+			temp, err := cp.GetExternalTemp(tExtractor.Method, tExtractor.Arg)
+			if err != nil {
+				fmt.Println("unable to extract temp for fan channel:", err.Error())
+				continue
+			}
+			for _, ch := range channels {
+				if err := cp.WriteFanExternalTemp(ch, temp); err != nil {
+					fmt.Println("unable to send temp to fan channel:", err.Error())
+				}
+			}
+		}
+	}
+
+	cp.fanTempTicker = time.NewTicker(time.Second * time.Duration(interval))
+	go func() {
+		feed()
+		for range cp.fanTempTicker.C {
+			feed()
+		}
+	}()
+}
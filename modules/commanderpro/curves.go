@@ -0,0 +1,62 @@
+package commanderpro
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// hardwareCurveExternalSensor is the hardwareCurve temp_sensor value
+// for a temp sent by tmi through WriteFanExternalTemp.
+const hardwareCurveExternalSensor = "external"
+
+type curvePoint struct {
+	Temp uint16 `yaml:"temp"`
+	RPM  uint16 `yaml:"rpm"`
+}
+
+// hardwareCurve is a six points temp/rpm curve
+// programmed into the device for a fan channel.
+type hardwareCurve struct {
+	Channel uint8 `yaml:"channel"`
+
+	// TempSensor is the internal sensor (1-4)
+	// or `external` for a temp sent by tmi.
+	TempSensor string `yaml:"temp_sensor"`
+
+	// ExternalTemp is the external_temps entry
+	// sent to the device for the external sensor.
+	ExternalTemp string `yaml:"external_temp"`
+
+	Points []curvePoint `yaml:"points"`
+}
+
+func (hc hardwareCurve) sensor() (TempSensor, error) {
+	if hc.TempSensor == hardwareCurveExternalSensor {
+		return TempSensorExternal, nil
+	}
+
+	nr, err := strconv.ParseUint(hc.TempSensor, 10, 8)
+	if err != nil || nr < 1 || nr > 4 {
+		return 0, fmt.Errorf("temp_sensor must be 1, 2, 3, 4 or %s", hardwareCurveExternalSensor)
+	}
+	return TempSensor(nr - 1), nil
+}
+
+func (cp *CommanderPro) applyHardwareCurve(hc hardwareCurve) error {
+	sensor, err := hc.sensor()
+	if err != nil {
+		return err
+	}
+
+	if len(hc.Points) != 6 {
+		return fmt.Errorf("a hardware curve needs exactly 6 points, %d found", len(hc.Points))
+	}
+
+	var temps, rpms [6]uint16
+	for i, p := range hc.Points {
+		temps[i] = p.Temp
+		rpms[i] = p.RPM
+	}
+
+	return cp.SetChannelCustomCurve(FanCh(hc.Channel), sensor, temps, rpms)
+}
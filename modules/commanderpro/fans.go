@@ -44,10 +44,11 @@ func (cp *CommanderPro) GetFanMask() (fan1, fan2, fan3, fan4, fan5, fan6 FanMode
 		nil
 }
 
-func (cp *CommanderPro) GetChannelRPM(fan FanCh) (rpm uint16, err error) {
+// rpmReader interface implementation.
+func (cp *CommanderPro) GetChannelRPM(fan uint8) (rpm uint16, err error) {
 	cmd := make([]byte, cp.outEndpoint.Desc.MaxPacketSize)
 	cmd[0] = byte(CMDGetFanRPM)
-	cmd[1] = byte(fan)
+	cmd[1] = fan
 
 	resp, err := cp.cmd(cmd)
 	if err != nil {
@@ -88,22 +89,23 @@ func (cp *CommanderPro) SetChannelDutyCycle(fan uint8, dutyCycle uint8) error {
 	return err
 }
 
-func (cp *CommanderPro) SetChannelFixedRPM(fan FanCh, rpm uint16) error {
+// rpmController interface implementation.
+func (cp *CommanderPro) SetChannelFixedRPM(fan uint8, rpm uint16) error {
 	if rpm == 0 {
-		return cp.SetFanMode(fan, FanModeUnknown)
+		return cp.SetFanMode(FanCh(fan), FanModeUnknown)
 	}
 
-	if fanMode, err := cp.GetFanMode(fan); err != nil {
+	if fanMode, err := cp.GetFanMode(FanCh(fan)); err != nil {
 		return err
 	} else if fanMode == FanModeUnknown {
-		if err := cp.SetFanMode(fan, FanModeAutoDisconnected); err != nil {
+		if err := cp.SetFanMode(FanCh(fan), FanModeAutoDisconnected); err != nil {
 			return err
 		}
 	}
 
 	cmd := make([]byte, cp.outEndpoint.Desc.MaxPacketSize)
 	cmd[0] = byte(CMDSetFanFixedRPM)
-	cmd[1] = byte(fan)
+	cmd[1] = fan
 	binary.BigEndian.PutUint16(cmd[2:4], rpm)
 
 	_, err := cp.cmd(cmd)
@@ -142,11 +144,12 @@ func (cp *CommanderPro) SetChannelCustomCurve(fan FanCh, tempSensor TempSensor,
 }
 
 // send to sensor? I don't think so... we send to a fan instead
-func (cp *CommanderPro) WriteFanExternalTemp(sensorIndex uint8, temp uint16) error {
+// The fan must use a custom curve with the TempSensorExternal sensor.
+func (cp *CommanderPro) WriteFanExternalTemp(fan uint8, temp float64) error {
 	cmd := make([]byte, cp.outEndpoint.Desc.MaxPacketSize)
 	cmd[0] = byte(CMDWriteFanExternalTemp)
-	cmd[1] = byte(sensorIndex)
-	binary.BigEndian.PutUint16(cmd[2:4], temp*100)
+	cmd[1] = fan
+	binary.BigEndian.PutUint16(cmd[2:4], uint16(temp*100))
 
 	_, err := cp.cmd(cmd)
 	return err
@@ -2,7 +2,10 @@ package commanderpro
 
 import (
 	"encoding/binary"
+	"fmt"
 	"strconv"
+	"strings"
+	"time"
 )
 
 const (
@@ -17,17 +20,43 @@ const (
 	TempSensor4        TempSensor = 0x03 // 4
 )
 
+// Voltage rails, CMDGetVoltage argument.
+const (
+	Rail12V = 0x00
+	Rail5V  = 0x01
+	Rail3V3 = 0x02
+)
+
+// connectedSensorsTTL is the lifetime of the connected sensors mask,
+// so that it's read once by the sensors of the same check.
+const connectedSensorsTTL = time.Second
+
 // tempExtractor interface implementation
 func (cp *CommanderPro) GetTemp(sensor string) (temp float64, err error) {
 	var sNR uint64
-	sNR, err = strconv.ParseUint(sensor, 16, 8)
-	if err == nil {
-		return
+	sNR, err = strconv.ParseUint(strings.TrimPrefix(sensor, "0x"), 16, 8)
+	if err != nil {
+		return 0, fmt.Errorf("invalid commanderpro temp sensor: %s", sensor)
+	}
+	if sNR > uint64(TempSensor4) {
+		return 0, fmt.Errorf("no such commanderpro temp sensor: %s, use 0x00-0x03", sensor)
+	}
+
+	connected, err := cp.connectedSensors()
+	if err != nil {
+		return 0, err
 	}
+	if !connected[sNR] {
+		return 0, fmt.Errorf("commanderpro temp sensor %d is not connected", sNR+1)
+	}
+
+	return cp.GetTempForSensor(TempSensor(sNR))
+}
 
+func (cp *CommanderPro) GetTempForSensor(sensor TempSensor) (temp float64, err error) {
 	cmd := make([]byte, cp.outEndpoint.Desc.MaxPacketSize)
 	cmd[0] = byte(CMDGetTemp)
-	cmd[1] = byte(sNR)
+	cmd[1] = byte(sensor)
 
 	resp, err := cp.cmd(cmd)
 	if err != nil {
@@ -36,14 +65,73 @@ func (cp *CommanderPro) GetTemp(sensor string) (temp float64, err error) {
 	return float64(binary.BigEndian.Uint16(resp[1:3])) / 100, nil
 }
 
-func (cp *CommanderPro) GetTempForSensor(sensor TempSensor) (temp float64, err error) {
+// GetConnectedSensors return the connection state of the four temp sensors.
+func (cp *CommanderPro) GetConnectedSensors() (connected [4]bool, err error) {
 	cmd := make([]byte, cp.outEndpoint.Desc.MaxPacketSize)
-	cmd[0] = byte(CMDGetTemp)
-	cmd[1] = byte(sensor)
+	cmd[0] = byte(CMDConnectedSensors)
+
+	resp, err := cp.cmd(cmd)
+	if err != nil {
+		return connected, err
+	}
+	for i := range connected {
+		connected[i] = resp[1+i] == 0x01
+	}
+	return connected, nil
+}
+
+// connectedSensors return the connected sensors mask,
+// read from the device at most once per connectedSensorsTTL.
+func (cp *CommanderPro) connectedSensors() (connected [4]bool, err error) {
+	cp.sensorsMutex.Lock()
+	defer cp.sensorsMutex.Unlock()
+
+	if !cp.sensorsRead.IsZero() && time.Since(cp.sensorsRead) < connectedSensorsTTL {
+		return cp.sensors, nil
+	}
+
+	if connected, err = cp.GetConnectedSensors(); err != nil {
+		return
+	}
+	cp.sensors, cp.sensorsRead = connected, time.Now()
+	return
+}
+
+// GetVoltage return the given rail voltage, in volts.
+func (cp *CommanderPro) GetVoltage(rail uint8) (volts float64, err error) {
+	cmd := make([]byte, cp.outEndpoint.Desc.MaxPacketSize)
+	cmd[0] = byte(CMDGetVoltage)
+	cmd[1] = rail
 
 	resp, err := cp.cmd(cmd)
 	if err != nil {
 		return 0, err
 	}
-	return float64(binary.BigEndian.Uint16(resp[1:3])) / 100, nil
+	return float64(binary.BigEndian.Uint16(resp[1:3])) / 1000, nil
+}
+
+// GetVoltages return the 12V, 5V and 3.3V rails voltage, in volts.
+func (cp *CommanderPro) GetVoltages() (v12, v5, v3v3 float64, err error) {
+	if v12, err = cp.GetVoltage(Rail12V); err != nil {
+		return
+	}
+	if v5, err = cp.GetVoltage(Rail5V); err != nil {
+		return
+	}
+	v3v3, err = cp.GetVoltage(Rail3V3)
+	return
+}
+
+// metricReader interface implementation, metrics are the rails voltage: 12v, 5v and 3.3v.
+func (cp *CommanderPro) GetMetric(name string) (value float64, err error) {
+	switch strings.ToLower(name) {
+	case "12v":
+		return cp.GetVoltage(Rail12V)
+	case "5v":
+		return cp.GetVoltage(Rail5V)
+	case "3.3v":
+		return cp.GetVoltage(Rail3V3)
+	default:
+		return 0, fmt.Errorf("no such commanderpro metric: %s, use 12v, 5v or 3.3v", name)
+	}
 }
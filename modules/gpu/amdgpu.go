@@ -0,0 +1,78 @@
+package gpu
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// DefaultDRMRoot is the sysfs drm class directory.
+const DefaultDRMRoot = "/sys/class/drm"
+
+// AMDGPU read the amdgpu temps from the card hwmon directory
+// (/sys/class/drm/cardN/device/hwmon/hwmonM) without shelling out.
+type AMDGPU struct {
+	root string
+}
+
+// NewAMDGPU return a new AMDGPU instance reading the cards in root,
+// DefaultDRMRoot if empty.
+func NewAMDGPU(root string) *AMDGPU {
+	if root == "" {
+		root = DefaultDRMRoot
+	}
+	return &AMDGPU{root: root}
+}
+
+func readString(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// module interface implementation
+func (amd *AMDGPU) Name() string {
+	return "amdgpu"
+}
+
+// tempExtractor interface implementation.
+// sensor is `cardN` for the edge temp, or `cardN/<label>`
+// for a labeled temp (edge, junction, mem).
+func (amd *AMDGPU) GetTemp(sensor string) (temp float64, err error) {
+	cardLabel := strings.SplitN(sensor, "/", 2)
+	label := "edge"
+	if len(cardLabel) == 2 {
+		label = cardLabel[1]
+	}
+
+	dirs, _ := filepath.Glob(filepath.Join(amd.root, cardLabel[0], "device", "hwmon", "hwmon*"))
+	if len(dirs) == 0 {
+		return 0, errors.New("no such amdgpu card: " + cardLabel[0])
+	}
+
+	labels, _ := filepath.Glob(filepath.Join(dirs[0], "temp*_label"))
+	for _, l := range labels {
+		if name, err := readString(l); err != nil || name != label {
+			continue
+		}
+
+		value, err := readString(strings.TrimSuffix(l, "_label") + "_input")
+		if err != nil {
+			return 0, err
+		}
+		milliDegrees, err := strconv.Atoi(value)
+		if err != nil {
+			return 0, err
+		}
+		return float64(milliDegrees) / 1000, nil
+	}
+
+	return 0, fmt.Errorf("no such amdgpu temp sensor: %s", sensor)
+}
@@ -0,0 +1,67 @@
+package gpu
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// recorded `nvidia-smi --query-gpu=index,temperature.gpu --format=csv,noheader,nounits -l 1` output
+const nvidiaRecording = `0, 41
+1, 38
+0, 43
+1, [N/A]
+
+0, 44
+`
+
+func Test_parseNvidiaLine(t *testing.T) {
+	got := make(map[string][]float64)
+	for _, line := range strings.Split(nvidiaRecording, "\n") {
+		if index, temp, ok := parseNvidiaLine(line); ok {
+			got[index] = append(got[index], temp)
+		}
+	}
+	require.Equal(t, map[string][]float64{
+		"0": {41, 43, 44},
+		"1": {38},
+	}, got)
+}
+
+func TestAMDGPU_GetTemp(t *testing.T) {
+	root, err := ioutil.TempDir("", "drm")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	hwmon := filepath.Join(root, "card0", "device", "hwmon", "hwmon3")
+	require.NoError(t, os.MkdirAll(hwmon, 0755))
+	files := map[string]string{
+		"temp1_input": "52000\n",
+		"temp1_label": "edge\n",
+		"temp2_input": "61000\n",
+		"temp2_label": "junction\n",
+	}
+	for name, content := range files {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(hwmon, name), []byte(content), 0644))
+	}
+
+	amd := NewAMDGPU(root)
+
+	temp, err := amd.GetTemp("card0")
+	require.NoError(t, err)
+	require.Equal(t, float64(52), temp)
+
+	temp, err = amd.GetTemp("card0/junction")
+	require.NoError(t, err)
+	require.Equal(t, float64(61), temp)
+
+	_, err = amd.GetTemp("card0/mem")
+	require.Error(t, err)
+
+	_, err = amd.GetTemp("card1")
+	require.Error(t, err)
+}
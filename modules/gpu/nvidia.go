@@ -0,0 +1,108 @@
+package gpu
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oblq/tmi/modules/cli"
+)
+
+// nvidiaQuery is the long-lived nvidia-smi command,
+// it prints a `<index>, <temp>` line per gpu every second.
+var nvidiaQuery = []string{"nvidia-smi", "--query-gpu=index,temperature.gpu", "--format=csv,noheader,nounits", "-l", "1"}
+
+// nvidiaMaxAge is the maximum age of a reading,
+// nvidia-smi is restarted if it prints nothing for longer.
+const nvidiaMaxAge = 5 * time.Second
+
+type reading struct {
+	temp float64
+	time time.Time
+}
+
+// Nvidia read the nvidia gpus temps from a single long-lived
+// nvidia-smi process, instead of one process per sample.
+// The process is restarted with backoff if it exits or hangs.
+type Nvidia struct {
+	mutex sync.Mutex
+
+	process *cli.LineStream
+
+	// <gpu index> : last reading
+	readings map[string]reading
+}
+
+// NewNvidia return a new Nvidia instance,
+// nvidia-smi is started on the first reading.
+func NewNvidia() *Nvidia {
+	return &Nvidia{readings: make(map[string]reading)}
+}
+
+// parseNvidiaLine parse a `<index>, <temp>` line of the nvidia-smi query output,
+// unparsable lines, eg.: `0, [N/A]`, return ok == false.
+func parseNvidiaLine(line string) (index string, temp float64, ok bool) {
+	fields := strings.Split(line, ",")
+	if len(fields) != 2 {
+		return
+	}
+	temp, err := strconv.ParseFloat(strings.TrimSpace(fields[1]), 64)
+	if err != nil {
+		return
+	}
+	return strings.TrimSpace(fields[0]), temp, true
+}
+
+func (nv *Nvidia) update(line string) {
+	index, temp, ok := parseNvidiaLine(line)
+	if !ok {
+		return
+	}
+	nv.mutex.Lock()
+	nv.readings[index] = reading{temp: temp, time: time.Now()}
+	nv.mutex.Unlock()
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// module interface implementation
+func (nv *Nvidia) Name() string {
+	return "nvidia"
+}
+
+// tempExtractor interface implementation,
+// index is the gpu index as reported by nvidia-smi (eg.: 0).
+func (nv *Nvidia) GetTemp(index string) (temp float64, err error) {
+	nv.mutex.Lock()
+	defer nv.mutex.Unlock()
+
+	if nv.process == nil {
+		nv.process = cli.NewLineStream("nvidia-smi", func() *exec.Cmd {
+			return exec.Command(nvidiaQuery[0], nvidiaQuery[1:]...)
+		}, nvidiaMaxAge, nv.update)
+		nv.process.Start()
+	}
+
+	r, ok := nv.readings[index]
+	if !ok {
+		return 0, fmt.Errorf("no reading for nvidia gpu %s yet", index)
+	}
+	if age := time.Since(r.time); age > nvidiaMaxAge {
+		return 0, fmt.Errorf("nvidia gpu %s reading is stale (%s old)", index, age.Round(time.Second))
+	}
+	return r.temp, nil
+}
+
+// closer interface implementation, stop nvidia-smi.
+func (nv *Nvidia) Close() {
+	nv.mutex.Lock()
+	defer nv.mutex.Unlock()
+
+	if nv.process != nil {
+		nv.process.Stop()
+		nv.process = nil
+	}
+}
@@ -0,0 +1,246 @@
+package hwmon
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultRoot is the sysfs hwmon class directory.
+const DefaultRoot = "/sys/class/hwmon"
+
+// pwmEnableManual is the pwmN_enable manual mode value.
+const pwmEnableManual = "1"
+
+type channel struct {
+	// Chip is the hwmon chip name, as in the hwmonN/name file (eg.: nct6798).
+	Chip string `yaml:"chip"`
+	// PWM is the pwm number, pwmN in the chip directory.
+	PWM int `yaml:"pwm"`
+}
+
+// Hwmon is a linux sysfs hwmon interface to read temps and to handle pwm fans.
+// Chips are selected by name since the hwmonN index can change across reboots.
+type Hwmon struct {
+	mutex sync.Mutex
+
+	root string
+
+	configPath string
+	configStat os.FileInfo
+
+	// Channels are the pwm fans, used as hwmon.<channel> in tmi targets_map.
+	Channels map[uint8]channel `yaml:"channels"`
+
+	// pwmN_enable files with their original value,
+	// restored on Close.
+	pwmEnables map[string]string
+}
+
+// New return a new Hwmon instance reading the chips in root,
+// DefaultRoot if empty.
+func New(root string) *Hwmon {
+	if root == "" {
+		root = DefaultRoot
+	}
+	return &Hwmon{root: root, pwmEnables: make(map[string]string)}
+}
+
+// LoadConfig read the hwmon fan channels.
+func (hw *Hwmon) LoadConfig() (err error) {
+	if hw.configStat, err = os.Stat(hw.configPath); err != nil {
+		return
+	}
+
+	config, err := ioutil.ReadFile(hw.configPath)
+	if err != nil {
+		return err
+	}
+
+	hw.mutex.Lock()
+	defer hw.mutex.Unlock()
+
+	hw.Channels = nil
+	if err = yaml.Unmarshal(config, hw); err != nil {
+		return err
+	}
+
+	fmt.Println("hwmon config updated")
+	return nil
+}
+
+func (hw *Hwmon) CheckConfig(configPath string) {
+	hw.configPath = filepath.Join(configPath, "hwmon.yaml")
+	if configStat, err := os.Stat(hw.configPath); err != nil {
+		fmt.Println("unable to stat config file:", err.Error())
+	} else if hw.configStat == nil || configStat.Size() != hw.configStat.Size() ||
+		configStat.ModTime() != hw.configStat.ModTime() {
+		hw.configStat = configStat
+		if err := hw.LoadConfig(); err != nil {
+			fmt.Println(err.Error())
+		}
+		return
+	}
+}
+
+// chipDir return the directory of the chip with the given name.
+func (hw *Hwmon) chipDir(chip string) (string, error) {
+	dirs, err := filepath.Glob(filepath.Join(hw.root, "hwmon*"))
+	if err != nil {
+		return "", err
+	}
+	for _, dir := range dirs {
+		name, err := readString(filepath.Join(dir, "name"))
+		if err == nil && name == chip {
+			return dir, nil
+		}
+	}
+	return "", fmt.Errorf("no such hwmon chip: %s", chip)
+}
+
+// pwmPath return the pwmN file path for the given channel.
+func (hw *Hwmon) pwmPath(ch uint8) (string, error) {
+	hw.mutex.Lock()
+	c, ok := hw.Channels[ch]
+	hw.mutex.Unlock()
+	if !ok {
+		return "", fmt.Errorf("no such hwmon channel: %d", ch)
+	}
+
+	dir, err := hw.chipDir(c.Chip)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, fmt.Sprintf("pwm%d", c.PWM)), nil
+}
+
+// setManual switch the pwm to manual mode,
+// saving the original mode to be restored on Close.
+func (hw *Hwmon) setManual(pwmPath string) error {
+	enablePath := pwmPath + "_enable"
+	mode, err := readString(enablePath)
+	if err != nil {
+		return err
+	}
+	if mode == pwmEnableManual {
+		return nil
+	}
+
+	hw.mutex.Lock()
+	if _, ok := hw.pwmEnables[enablePath]; !ok {
+		hw.pwmEnables[enablePath] = mode
+	}
+	hw.mutex.Unlock()
+
+	return ioutil.WriteFile(enablePath, []byte(pwmEnableManual), 0644)
+}
+
+func readString(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func readInt(path string) (int, error) {
+	s, err := readString(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(s)
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// module interface implementation
+func (hw *Hwmon) Name() string {
+	return "hwmon"
+}
+
+// tempExtractor interface implementation.
+// sensor is `<chip>/tempN` (eg.: nct6798/temp2)
+// or `<chip>/<label>` matching a tempN_label file (eg.: k10temp/Tctl).
+func (hw *Hwmon) GetTemp(sensor string) (temp float64, err error) {
+	chipSensor := strings.SplitN(sensor, "/", 2)
+	if len(chipSensor) < 2 {
+		return 0, errors.New("hwmon temp must be `<chip>/tempN` or `<chip>/<label>`, got: " + sensor)
+	}
+
+	dir, err := hw.chipDir(chipSensor[0])
+	if err != nil {
+		return 0, err
+	}
+
+	input := filepath.Join(dir, chipSensor[1]+"_input")
+	if _, err := os.Stat(input); err != nil {
+		labels, _ := filepath.Glob(filepath.Join(dir, "temp*_label"))
+		input = ""
+		for _, label := range labels {
+			if l, err := readString(label); err == nil && l == chipSensor[1] {
+				input = strings.TrimSuffix(label, "_label") + "_input"
+				break
+			}
+		}
+		if input == "" {
+			return 0, fmt.Errorf("no such hwmon temp sensor: %s", sensor)
+		}
+	}
+
+	milliDegrees, err := readInt(input)
+	if err != nil {
+		return 0, err
+	}
+	return float64(milliDegrees) / 1000, nil
+}
+
+// fanController interface implementation.
+func (hw *Hwmon) SetChannelDutyCycle(ch uint8, dc uint8) error {
+	pwmPath, err := hw.pwmPath(ch)
+	if err != nil {
+		return err
+	}
+
+	if err = hw.setManual(pwmPath); err != nil {
+		return fmt.Errorf("unable to set manual mode for hwmon channel %d: %v", ch, err)
+	}
+
+	pwm := int(math.Round(float64(dc) * 255 / 100))
+	return ioutil.WriteFile(pwmPath, []byte(strconv.Itoa(pwm)), 0644)
+}
+
+// fanController interface implementation.
+func (hw *Hwmon) GetChannelDutyCycle(ch uint8) (dc uint8, err error) {
+	pwmPath, err := hw.pwmPath(ch)
+	if err != nil {
+		return 0, err
+	}
+
+	pwm, err := readInt(pwmPath)
+	if err != nil {
+		return 0, err
+	}
+	return uint8(math.Round(float64(pwm) * 100 / 255)), nil
+}
+
+// closer interface implementation,
+// restore the original (usually automatic) mode of the pwm fans.
+func (hw *Hwmon) Close() {
+	hw.mutex.Lock()
+	defer hw.mutex.Unlock()
+
+	for enablePath, mode := range hw.pwmEnables {
+		if err := ioutil.WriteFile(enablePath, []byte(mode), 0644); err != nil {
+			fmt.Println("unable to restore hwmon pwm mode:", err.Error())
+		}
+	}
+	hw.pwmEnables = make(map[string]string)
+}
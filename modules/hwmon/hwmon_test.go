@@ -0,0 +1,103 @@
+package hwmon
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSysfs create a fake hwmon class directory:
+// hwmon0 is k10temp, hwmon1 is nct6798 with two pwm fans.
+func fakeSysfs(t *testing.T) string {
+	root, err := ioutil.TempDir("", "hwmon")
+	require.NoError(t, err)
+
+	files := map[string]string{
+		"hwmon0/name":        "k10temp\n",
+		"hwmon0/temp1_input": "45250\n",
+		"hwmon0/temp1_label": "Tctl\n",
+		"hwmon1/name":        "nct6798\n",
+		"hwmon1/temp2_input": "38000\n",
+		"hwmon1/pwm1":        "128\n",
+		"hwmon1/pwm1_enable": "5\n",
+		"hwmon1/pwm2":        "255\n",
+		"hwmon1/pwm2_enable": "1\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	}
+	return root
+}
+
+func readFile(t *testing.T, path string) string {
+	s, err := readString(path)
+	require.NoError(t, err)
+	return s
+}
+
+func TestHwmon_GetTemp(t *testing.T) {
+	root := fakeSysfs(t)
+	defer os.RemoveAll(root)
+
+	hw := New(root)
+
+	tests := []struct {
+		sensor  string
+		want    float64
+		wantErr bool
+	}{
+		{sensor: "nct6798/temp2", want: 38},
+		{sensor: "k10temp/temp1", want: 45.25},
+		{sensor: "k10temp/Tctl", want: 45.25},
+		{sensor: "k10temp/Tdie", wantErr: true},
+		{sensor: "nope/temp1", wantErr: true},
+		{sensor: "temp1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.sensor, func(t *testing.T) {
+			got, err := hw.GetTemp(tt.sensor)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestHwmon_dutyCycle(t *testing.T) {
+	root := fakeSysfs(t)
+	defer os.RemoveAll(root)
+
+	hw := New(root)
+	hw.Channels = map[uint8]channel{
+		0: {Chip: "nct6798", PWM: 1},
+		1: {Chip: "nct6798", PWM: 2},
+	}
+
+	dc, err := hw.GetChannelDutyCycle(0)
+	require.NoError(t, err)
+	require.Equal(t, uint8(50), dc)
+
+	require.NoError(t, hw.SetChannelDutyCycle(0, 30))
+	require.Equal(t, "77", readFile(t, filepath.Join(root, "hwmon1/pwm1")))
+	require.Equal(t, "1", readFile(t, filepath.Join(root, "hwmon1/pwm1_enable")))
+
+	require.NoError(t, hw.SetChannelDutyCycle(1, 100))
+	require.Equal(t, "255", readFile(t, filepath.Join(root, "hwmon1/pwm2")))
+
+	_, err = hw.GetChannelDutyCycle(2)
+	require.Error(t, err)
+
+	// only the pwm switched to manual by tmi is restored
+	hw.Close()
+	require.Equal(t, "5", readFile(t, filepath.Join(root, "hwmon1/pwm1_enable")))
+	require.Equal(t, "1", readFile(t, filepath.Join(root, "hwmon1/pwm2_enable")))
+}
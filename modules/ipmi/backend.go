@@ -0,0 +1,119 @@
+package ipmi
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/oblq/tmi/modules/cli"
+)
+
+const (
+	// BackendIpmitool run the ipmitool binary for every request.
+	BackendIpmitool = "ipmitool"
+	// BackendNative talk RMCP+ (lanplus) to the BMC in-process.
+	BackendNative = "native"
+)
+
+// backend is the transport used to talk to the BMC.
+type backend interface {
+	// raw send a raw request, returning the response data bytes.
+	raw(netFn, cmd byte, data ...byte) ([]byte, error)
+
+	// sdrElist return the records of all the types (`sdr elist`),
+	// only the ones of the given entity (`sdr entity`) if entityID is not empty.
+	sdrElist(entityID string) ([]SDRRecord, error)
+
+	// sdrElistFull return the full sensor records (`sdr elist full`).
+	sdrElistFull() ([]SDRRecord, error)
+
+	// sdrFans return the `sdr type Fan` records.
+	sdrFans() ([]SDRRecord, error)
+
+	// setThresholds set the lower (Non-Recoverable, Critical, Non-Critical)
+	// or upper (Non-Critical, Critical, Non-Recoverable) thresholds of a sensor.
+	setThresholds(sensor string, upper bool, values []string) error
+
+	close()
+}
+
+// ipmitool is the backend running the ipmitool binary.
+type ipmitool struct {
+	// cmd is the ipmitool preamble command.
+	cmd     string
+	timeout time.Duration
+}
+
+// command run an ipmitool command with the configured timeout.
+func (it *ipmitool) command(args string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), it.timeout)
+	defer cancel()
+	return cli.CommandContext(ctx, it.cmd+" "+args)
+}
+
+func (it *ipmitool) raw(netFn, cmd byte, data ...byte) ([]byte, error) {
+	out, err := it.command(newRaw(netFn, cmd, data...).String())
+	if err != nil {
+		return nil, err
+	}
+
+	// hex bytes separated by spaces, on one or more lines
+	resp := make([]byte, 0)
+	for _, field := range strings.Fields(out) {
+		b, err := strconv.ParseUint(field, 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected ipmitool raw output: %s", out)
+		}
+		resp = append(resp, byte(b))
+	}
+	return resp, nil
+}
+
+func (it *ipmitool) sdrElist(entityID string) ([]SDRRecord, error) {
+	// all the record types, as `sdr entity`
+	args := "sdr elist"
+	if entityID != "" {
+		args = "sdr entity " + entityID
+	}
+
+	out, err := it.command(args)
+	if err != nil {
+		return nil, err
+	}
+	return parseSDRElist(out), nil
+}
+
+func (it *ipmitool) sdrElistFull() ([]SDRRecord, error) {
+	out, err := it.command("sdr elist full")
+	if err != nil {
+		return nil, err
+	}
+	return parseSDRElist(out), nil
+}
+
+func (it *ipmitool) sdrFans() ([]SDRRecord, error) {
+	out, err := it.command("sdr type Fan")
+	if err != nil {
+		return nil, err
+	}
+	return parseSDRElist(out), nil
+}
+
+func (it *ipmitool) setThresholds(sensor string, upper bool, values []string) error {
+	which := "lower"
+	if upper {
+		which = "upper"
+	}
+
+	out, err := it.command(fmt.Sprintf("sensor thresh %s %s %s", sensor, which, strings.Join(values, " ")))
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(out)
+	return nil
+}
+
+func (it *ipmitool) close() {}
@@ -2,9 +2,6 @@ package ipmi
 
 import (
 	"errors"
-	"fmt"
-
-	"github.com/oblq/tmi/modules/cli"
 )
 
 type fanThreshold struct {
@@ -14,7 +11,8 @@ type fanThreshold struct {
 	Upper       []string `yaml:"upper"`
 }
 
-func (t *fanThreshold) set(ipmiCMD string) error {
+// set the thresholds through the given backend.
+func (t *fanThreshold) set(b backend) error {
 	if len(t.Lower) < 3 {
 		return errors.New("lower thresholds must have three values: Non-Recoverable, Critical and Non-Critical")
 	}
@@ -23,27 +21,9 @@ func (t *fanThreshold) set(ipmiCMD string) error {
 		return errors.New("upper thresholds must have three values: Non-Critical, Critical and Non-Recoverable")
 	}
 
-	var err error
-	var out string
-
-	cmdLower := fmt.Sprintf("%s sensor thresh %s lower %s %s %s",
-		ipmiCMD, t.Name, t.Lower[0], t.Lower[1], t.Lower[2])
-
-	out, err = cli.Command(cmdLower)
-	if err != nil {
-		return err
-	}
-
-	fmt.Println(out)
-
-	cmdUpper := fmt.Sprintf("%s sensor thresh %s upper %s %s %s",
-		ipmiCMD, t.Name, t.Upper[0], t.Upper[1], t.Upper[2])
-
-	out, err = cli.Command(cmdUpper)
-	if err != nil {
+	if err := b.setThresholds(t.Name, false, t.Lower[:3]); err != nil {
 		return err
 	}
 
-	fmt.Println(out)
-	return nil
+	return b.setThresholds(t.Name, true, t.Upper[:3])
 }
@@ -5,8 +5,8 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
-	"strconv"
-	"strings"
+	"sync"
+	"time"
 
 	"github.com/oblq/tmi/modules/cli"
 	"gopkg.in/yaml.v3"
@@ -20,39 +20,213 @@ const (
 	FanModeFull     fanMode = "01"
 	FanModeOptimal  fanMode = "02"
 	FanModeHeavyIO  fanMode = "04"
-	FanModeCustom   fanMode = "custom"
 )
 
-// IPMI is an ipmitool interface to handle fans duty-cycles.
-type IPMI struct {
-	configPath string
-	configStat os.FileInfo
-
-	zonesDutyCycles map[string]uint8
+// fanModes are the configurable fan modes by name.
+var fanModes = map[string]fanMode{
+	"standard": FanModeStandard,
+	"full":     FanModeFull,
+	"optimal":  FanModeOptimal,
+	"heavy_io": FanModeHeavyIO,
+}
 
+// Config is the ipmi.yaml configuration.
+type Config struct {
 	// CMD is the ipmitool preamble command,
 	// could be act locally or on remote machines,
 	// depending on the parameters, full example in config file.
 	CMD string `yaml:"cmd"`
 
+	// Backend is ipmitool (default) or native,
+	// the native backend talks RMCP+ (lanplus) to the BMC in-process,
+	// reusing the same session, CMD is ignored in that case.
+	Backend string `yaml:"backend"`
+
+	// LAN is the BMC used by the native backend.
+	LAN lanConfig `yaml:"lan"`
+
+	// Vendor is the raw fan commands profile: auto (default), supermicro_x9,
+	// supermicro_x10, supermicro_x11, dell or asrock_rack.
+	// auto detects it from the `mc info` manufacturer ID,
+	// Supermicro boards must be set explicitly, an empty vendor falls back to supermicro_x11 for them.
+	Vendor string `yaml:"vendor"`
+
+	// Timeout is the ipmitool commands timeout, in seconds,
+	// an unreachable BMC would block the fan control otherwise.
+	Timeout int `yaml:"timeout"`
+
+	// SDRCacheTTL is the lifetime of the sensors readings, in seconds,
+	// shared by all the ipmi controllers, 1 by default, negative disables the cache.
+	SDRCacheTTL int `yaml:"sdr_cache_ttl"`
+
 	// FanThresholds are some custom fan thresholds,
 	// Noctua fans needs this for instance.
 	FanThresholds map[string]*fanThreshold `yaml:"fan_thresholds"`
 
-	// fanMode is the ipmi fan mode.
-	// Must be set to custom to use the configured controllers.
-	//fanMode fanMode `yaml:"fan_mode"`
+	// FanMode is the BMC fan mode: standard, full (default), optimal or heavy_io.
+	// The BMC overrides the zones duty-cycles in any mode but full.
+	FanMode string `yaml:"fan_mode"`
+}
+
+// IPMI is an ipmitool interface to handle fans duty-cycles.
+type IPMI struct {
+	configPath string
+	configStat os.FileInfo
+
+	// zonesDutyCycles are the last duty-cycles set,
+	// for the vendors unable to read them back.
+	zonesDutyCycles map[uint8]uint8
+
+	// mutex guard the config and what is built from it,
+	// swapped at once by ReadConfig while the temps are read
+	// by other goroutines (eg.: the commanderpro external temps).
+	mutex sync.RWMutex
+
+	Config `yaml:",inline"`
+
+	backend  backend
+	profile  vendorProfile
+	sdrCache *sdrCache
+
+	// originalMode is the fan mode the BMC had before tmi started,
+	// restored on Close.
+	originalMode fanMode
 }
 
 // New return a new IPMI instance.
 func New() (ipmi *IPMI, err error) {
-	ipmi = &IPMI{zonesDutyCycles: make(map[string]uint8)}
+	ipmi = &IPMI{
+		zonesDutyCycles: make(map[uint8]uint8),
+		backend:         &ipmitool{timeout: cli.DefaultTimeout},
+		sdrCache:        &sdrCache{ttl: defaultSDRCacheTTL},
+	}
 
 	//err = ipmi.LoadConfig()
 
 	return
 }
 
+// ReadConfig read the ipmi.yaml config in configPath
+// without applying it to the device.
+func (ipmi *IPMI) ReadConfig(configPath string) error {
+	data, err := ioutil.ReadFile(filepath.Join(configPath, "ipmi.yaml"))
+	if err != nil {
+		return err
+	}
+	var config Config
+	if err = yaml.Unmarshal(data, &config); err != nil {
+		return err
+	}
+
+	if _, ok := fanModes[config.FanMode]; !ok && config.FanMode != "" {
+		return fmt.Errorf("unknown ipmi fan_mode `%s`, use standard, full, optimal or heavy_io", config.FanMode)
+	}
+
+	if config.Vendor != "" && config.Vendor != VendorAuto {
+		if _, err = newProfile(config.Vendor); err != nil {
+			return err
+		}
+	}
+
+	var b backend
+	switch config.Backend {
+	case "", BackendIpmitool:
+		b = &ipmitool{cmd: config.CMD, timeout: config.requestTimeout()}
+	case BackendNative:
+		b = newLAN(config.LAN, config.requestTimeout())
+	default:
+		return fmt.Errorf("unknown ipmi backend `%s`, use ipmitool or native", config.Backend)
+	}
+
+	// the cached readings could come from another BMC
+	var cache *sdrCache
+	switch {
+	case config.SDRCacheTTL == 0:
+		cache = &sdrCache{ttl: defaultSDRCacheTTL}
+	case config.SDRCacheTTL > 0:
+		cache = &sdrCache{ttl: time.Second * time.Duration(config.SDRCacheTTL)}
+	}
+
+	ipmi.mutex.Lock()
+	old := ipmi.backend
+	ipmi.Config = config
+	ipmi.backend = b
+	ipmi.profile = nil
+	ipmi.sdrCache = cache
+	ipmi.mutex.Unlock()
+
+	if old != nil {
+		old.close()
+	}
+	return nil
+}
+
+// current return the backend and the sdr cache of the current config.
+func (ipmi *IPMI) current() (backend, *sdrCache) {
+	ipmi.mutex.RLock()
+	defer ipmi.mutex.RUnlock()
+	return ipmi.backend, ipmi.sdrCache
+}
+
+// vendorProfile return the configured or detected vendor profile.
+func (ipmi *IPMI) vendorProfile() (vendorProfile, error) {
+	ipmi.mutex.Lock()
+	defer ipmi.mutex.Unlock()
+
+	if ipmi.profile != nil {
+		return ipmi.profile, nil
+	}
+
+	vendor := ipmi.Vendor
+	if vendor == "" || vendor == VendorAuto {
+		var err error
+		vendor, err = detectVendor(ipmi.backend)
+		switch {
+		case err == errSupermicro && ipmi.Vendor == "":
+			// the configs without a vendor predate the profiles, X11 was the only one
+			vendor = VendorSupermicroX11
+			fmt.Println("warning: supermicro board without an ipmi vendor, using supermicro_x11, set `vendor` in ipmi.yaml")
+		case err != nil:
+			return nil, fmt.Errorf("unable to detect the ipmi vendor: %v", err)
+		default:
+			fmt.Println("ipmi vendor detected:", vendor)
+		}
+	}
+
+	profile, err := newProfile(vendor)
+	if err != nil {
+		return nil, err
+	}
+	if s, ok := profile.(seeder); ok {
+		req, err := profile.getDuty(0)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := ipmi.backend.raw(req.netFn, req.cmd, req.data...)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the current duty-cycles: %w", err)
+		}
+		s.seed(resp)
+	}
+	ipmi.profile = profile
+	return profile, nil
+}
+
+// request send the request built by the vendor profile.
+func (ipmi *IPMI) request(build func(p vendorProfile) (rawRequest, error)) (vendorProfile, []byte, error) {
+	p, err := ipmi.vendorProfile()
+	if err != nil {
+		return nil, nil, err
+	}
+	req, err := build(p)
+	if err != nil {
+		return p, nil, err
+	}
+	b, _ := ipmi.current()
+	resp, err := b.raw(req.netFn, req.cmd, req.data...)
+	return p, resp, err
+}
+
 // LoadConfigThresholds will update ipmi fan thresholds.
 // `sudo watch ipmitool sensor` to get the current settings.
 func (ipmi *IPMI) LoadConfig() (err error) {
@@ -60,25 +234,44 @@ func (ipmi *IPMI) LoadConfig() (err error) {
 		return
 	}
 
-	config, err := ioutil.ReadFile(ipmi.configPath)
-	if err != nil {
-		return err
-	}
-	err = yaml.Unmarshal(config, &ipmi)
-	if err != nil {
+	if err = ipmi.ReadConfig(filepath.Dir(ipmi.configPath)); err != nil {
 		return err
 	}
 
-	for name, fanThreshold := range ipmi.FanThresholds {
+	ipmi.mutex.RLock()
+	config, b := ipmi.Config, ipmi.backend
+	ipmi.mutex.RUnlock()
+
+	for name, fanThreshold := range config.FanThresholds {
 		fanThreshold.Name = name
-		if err := fanThreshold.set(ipmi.CMD); err != nil {
+		if err := fanThreshold.set(b); err != nil {
 			fmt.Println("error setting fans threshold:", err.Error())
 		}
 	}
 
-	currMode := ipmi.GetFanMode()
-	if currMode != string(FanModeFull) {
-		ipmi.SetFanMode(FanModeFull)
+	mode := FanModeFull
+	if config.FanMode != "" {
+		mode = fanModes[config.FanMode]
+	}
+
+	currMode, err := ipmi.getFanMode()
+	switch {
+	case err == errNotSupported:
+		// the BMC automatic control is assumed
+		if ipmi.originalMode == "" {
+			ipmi.originalMode = FanModeStandard
+		}
+		ipmi.SetFanMode(mode)
+	case err != nil:
+		fmt.Println("error getting fan mode", err.Error())
+		ipmi.SetFanMode(mode)
+	default:
+		if ipmi.originalMode == "" {
+			ipmi.originalMode = currMode
+		}
+		if currMode != mode {
+			ipmi.SetFanMode(mode)
+		}
 	}
 
 	fmt.Println("ipmi config updated")
@@ -100,18 +293,53 @@ func (ipmi *IPMI) CheckConfig(configPath string) {
 	}
 }
 
+// RequestTimeout return the ipmitool commands (or native requests) timeout.
+func (ipmi *IPMI) RequestTimeout() time.Duration {
+	ipmi.mutex.RLock()
+	defer ipmi.mutex.RUnlock()
+	return ipmi.requestTimeout()
+}
+
+func (config *Config) requestTimeout() time.Duration {
+	if config.Timeout <= 0 {
+		return cli.DefaultTimeout
+	}
+	return time.Second * time.Duration(config.Timeout)
+}
+
+// Zones return the fan zones (the targets_map channels) of the vendor profile.
+func (ipmi *IPMI) Zones() ([]uint8, error) {
+	p, err := ipmi.vendorProfile()
+	if err != nil {
+		return nil, err
+	}
+	return p.zones(), nil
+}
+
 // GetFanMode return the fan mode currently used by ipmi.
 func (ipmi *IPMI) GetFanMode() string {
-	out, err := cli.Command(fmt.Sprintf("%s raw 0x30 0x45 0x00", ipmi.CMD))
+	mode, err := ipmi.getFanMode()
 	if err != nil {
 		fmt.Println("error getting fan mode", err.Error())
 	}
-	return strings.Trim(out, " ")
+	return string(mode)
+}
+
+func (ipmi *IPMI) getFanMode() (fanMode, error) {
+	p, resp, err := ipmi.request(func(p vendorProfile) (rawRequest, error) {
+		return p.getMode()
+	})
+	if err != nil {
+		return "", err
+	}
+	return p.mode(resp)
 }
 
 // SetFanMode set ipmi fan mode.
 func (ipmi *IPMI) SetFanMode(mode fanMode) {
-	_, err := cli.Command(fmt.Sprintf("%s raw 0x30 0x45 0x01 %s", ipmi.CMD, mode))
+	_, _, err := ipmi.request(func(p vendorProfile) (rawRequest, error) {
+		return p.setMode(mode)
+	})
 	if err != nil {
 		fmt.Println("error setting fan mode to", mode, "->", err.Error())
 	} else {
@@ -119,16 +347,40 @@ func (ipmi *IPMI) SetFanMode(mode fanMode) {
 	}
 }
 
-// GetZoneDutyCycle return the passed zone duty-cycle.
+// GetZoneDutyCycle return the passed zone duty-cycle,
+// the last one set if the vendor can't read it back.
 func (ipmi *IPMI) GetChannelDutyCycle(ch uint8) (uint8, error) {
-	out, err := cli.Command(fmt.Sprintf("%s raw 0x30 0x70 0x66 0x00 %#02x", ipmi.CMD, ch))
+	p, resp, err := ipmi.request(func(p vendorProfile) (rawRequest, error) {
+		return p.getDuty(ch)
+	})
+	if err == errNotSupported {
+		return ipmi.zonesDutyCycles[ch], nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error getting duty cycle for zone '%v', err: %w", ch, err)
+	}
+
+	dc, err := p.duty(ch, resp)
 	if err != nil {
-		return 0, fmt.Errorf("error getting duty cycle for zone '%v', err: %v", ch, err)
+		return 0, fmt.Errorf("error getting duty cycle for zone '%v', err: %w", ch, err)
 	}
+	return dc, nil
+}
 
-	out = strings.Trim(out, " ")
-	dc, err := strconv.ParseUint(out, 16, 8)
-	return uint8(dc), err
+// Close restore the fan mode the BMC had before tmi started,
+// so that it's never left without thermal management,
+// then close the backend session, if any.
+func (ipmi *IPMI) Close() {
+	if ipmi.originalMode != "" {
+		if mode, err := ipmi.getFanMode(); err != nil || mode != ipmi.originalMode {
+			ipmi.SetFanMode(ipmi.originalMode)
+		}
+	}
+	ipmi.originalMode = ""
+
+	if b, _ := ipmi.current(); b != nil {
+		b.close()
+	}
 }
 
 // ---------------------------------------------------------------------------------------------------------------------
@@ -138,29 +390,35 @@ func (ipmi *IPMI) Name() string {
 	return "ipmi"
 }
 
-// tempExtractor interface implementation
-func (ipmi *IPMI) GetTemp(entityID string) (temp float64, err error) {
-	var tString string
-	cmdString := fmt.Sprintf("%s sdr entity %s | cut -d '|' -f 5 | cut -d ' ' -f2", ipmi.CMD, entityID)
-	tString, err = cli.CommandPipe(cmdString)
+// tempExtractor interface implementation.
+// arg select the sensor by entity ID (`3.1`), by entity ID and
+// the index of its sensor (`3.1:1`) or by sensor name (`CPU Temp`).
+// Sensors without a reading return a *StatusError,
+// sensors which are not temperatures an *UnitError.
+func (ipmi *IPMI) GetTemp(arg string) (temp float64, err error) {
+	selector := parseSensorSelector(arg)
+
+	records, err := ipmi.sdrElist(selector.entityID)
 	if err != nil {
-		return
-	}
-	if tString == "" {
-		err = fmt.Errorf("entityID not found: %s", entityID)
-		return
+		return 0, err
 	}
 
-	tString = strings.Trim(tString, " .")
-	return strconv.ParseFloat(tString, 32)
+	record, err := selector.selectSensor(records)
+	if err != nil {
+		return 0, err
+	}
+	return record.temperature()
 }
 
 // fanController interface implementation.
 // SetZoneDutyCycle set the passed duty-cycle for the given zone.
 func (ipmi *IPMI) SetChannelDutyCycle(ch uint8, dc uint8) error {
-	cmdString := fmt.Sprintf("%s raw 0x30 0x70 0x66 0x01 %#02x %#02x", ipmi.CMD, ch, dc)
-	if _, err := cli.Command(cmdString); err != nil {
-		return fmt.Errorf("error setting duty cycle for zone '%v' to %d%%, err: %v", ch, dc, err)
+	_, _, err := ipmi.request(func(p vendorProfile) (rawRequest, error) {
+		return p.setDuty(ch, dc)
+	})
+	if err != nil {
+		return fmt.Errorf("error setting duty cycle for zone '%v' to %d%%, err: %w", ch, dc, err)
 	}
+	ipmi.zonesDutyCycles[ch] = dc
 	return nil
 }
@@ -0,0 +1,99 @@
+package ipmi
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/oblq/tmi/modules/cli"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIPMI_fanMode(t *testing.T) {
+	bmc := newFakeBMC(t)
+	defer bmc.conn.Close()
+
+	bmc.mutex.Lock()
+	bmc.fanMode = 0x00
+	bmc.mutex.Unlock()
+
+	dir, err := ioutil.TempDir("", "ipmi")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	config := bmc.config()
+	writeConfig := func(mode string) {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "ipmi.yaml"), []byte(fmt.Sprintf(
+			"backend: native\nlan: {host: %s, port: %d, username: %s, password: %s}\nvendor: supermicro_x11\nfan_mode: %s\n",
+			config.Host, config.Port, config.Username, config.Password, mode)), 0644))
+	}
+	fanMode := func() byte {
+		bmc.mutex.Lock()
+		defer bmc.mutex.Unlock()
+		return bmc.fanMode
+	}
+
+	ipmi, err := New()
+	require.NoError(t, err)
+	ipmi.configPath = filepath.Join(dir, "ipmi.yaml")
+
+	writeConfig("optimal")
+	require.NoError(t, ipmi.LoadConfig())
+	require.Equal(t, byte(0x02), fanMode())
+
+	writeConfig("full")
+	require.NoError(t, ipmi.LoadConfig())
+	require.Equal(t, byte(0x01), fanMode())
+
+	writeConfig("turbo")
+	require.Error(t, ipmi.LoadConfig())
+
+	// the mode before the first load is restored
+	ipmi.Close()
+	require.Equal(t, byte(0x00), fanMode())
+}
+
+// timeoutBackend time out every raw request.
+type timeoutBackend struct {
+	backend
+}
+
+func (b timeoutBackend) raw(netFn, cmd byte, data ...byte) ([]byte, error) {
+	return nil, fmt.Errorf("%w: `ipmitool raw`", cli.ErrTimeout)
+}
+
+func TestIPMI_dutyCycle_timeout(t *testing.T) {
+	ipmi := &IPMI{Config: Config{Vendor: VendorSupermicroX11}, backend: timeoutBackend{}, zonesDutyCycles: make(map[uint8]uint8)}
+
+	err := ipmi.SetChannelDutyCycle(0, 50)
+	require.True(t, errors.Is(err, cli.ErrTimeout), err)
+
+	_, err = ipmi.GetChannelDutyCycle(0)
+	require.True(t, errors.Is(err, cli.ErrTimeout), err)
+}
+
+func TestIPMI_ReadConfig_concurrentGetTemp(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ipmi")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "ipmi.yaml"), []byte("cmd: true\nvendor: supermicro_x11\n"), 0644))
+
+	ipmi, err := New()
+	require.NoError(t, err)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			_, _ = ipmi.GetTemp("3.1")
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		require.NoError(t, ipmi.ReadConfig(dir))
+	}
+	<-done
+	require.Equal(t, VendorSupermicroX11, ipmi.Vendor)
+}
@@ -0,0 +1,503 @@
+package ipmi
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/oblq/tmi/modules/cli"
+)
+
+// RMCP+ (IPMI v2.0 lanplus) constants.
+const (
+	rmcpVersion      = 0x06
+	rmcpClassIPMI    = 0x07
+	authTypeRMCPPlus = 0x06
+
+	payloadIPMI                = 0x00
+	payloadOpenSessionRequest  = 0x10
+	payloadOpenSessionResponse = 0x11
+	payloadRAKP1               = 0x12
+	payloadRAKP2               = 0x13
+	payloadRAKP3               = 0x14
+	payloadRAKP4               = 0x15
+
+	payloadEncrypted     = 0x80
+	payloadAuthenticated = 0x40
+
+	// RAKP-HMAC-SHA1, HMAC-SHA1-96 and AES-CBC-128,
+	// cipher suite 3, the one used by default by ipmitool.
+	algAuthHMACSHA1      = 0x01
+	algIntegrityHMACSHA1 = 0x01
+	algConfAESCBC128     = 0x01
+
+	integrityLen = 12
+
+	bmcAddr     = 0x20
+	consoleAddr = 0x81
+
+	netFnApp     = 0x06
+	netFnSensor  = 0x04
+	netFnStorage = 0x0a
+
+	cmdSetSessionPrivilege = 0x3b
+	cmdCloseSession        = 0x3c
+
+	privilegeAdministrator = 0x04
+)
+
+// privileges are the session privilege levels by name.
+var privileges = map[string]byte{
+	"user":          0x02,
+	"operator":      0x03,
+	"administrator": privilegeAdministrator,
+}
+
+// lanConfig is the remote BMC used by the native backend.
+type lanConfig struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// Privilege is user, operator or administrator (default).
+	Privilege string `yaml:"privilege"`
+}
+
+// completionError is a non-zero completion code returned by the BMC,
+// the session is still valid.
+type completionError struct {
+	netFn, cmd, code byte
+}
+
+func (e completionError) Error() string {
+	return fmt.Sprintf("ipmi request 0x%02x 0x%02x failed with completion code 0x%02x", e.netFn, e.cmd, e.code)
+}
+
+// lan is the native backend, an RMCP+ client
+// reusing the same session for every request.
+type lan struct {
+	config  lanConfig
+	timeout time.Duration
+
+	mutex sync.Mutex
+
+	// deadline of the current request, see do
+	deadline time.Time
+
+	conn      net.Conn
+	keys      *sessionKeys
+	consoleID uint32
+	bmcID     uint32
+	seq       uint32
+	rqSeq     byte
+
+	// sensor records, read once per session
+	sensors []sensorRecord
+}
+
+func newLAN(config lanConfig, timeout time.Duration) *lan {
+	if config.Port == 0 {
+		config.Port = 623
+	}
+	return &lan{config: config, timeout: timeout}
+}
+
+// sessionKeys are the integrity (k1) and confidentiality (k2) keys of a session.
+type sessionKeys struct {
+	k1, k2 []byte
+}
+
+func hmacSHA1(key []byte, data ...[]byte) []byte {
+	mac := hmac.New(sha1.New, key)
+	for _, d := range data {
+		mac.Write(d)
+	}
+	return mac.Sum(nil)
+}
+
+func uint32LE(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, v)
+	return b
+}
+
+// encode an RMCP+ packet, authenticated and encrypted if keys is not nil.
+func (keys *sessionKeys) encode(payloadType byte, sessionID, seq uint32, payload []byte) ([]byte, error) {
+	if keys != nil {
+		var err error
+		if payload, err = encryptAESCBC(keys.k2[:16], payload); err != nil {
+			return nil, err
+		}
+		payloadType |= payloadEncrypted | payloadAuthenticated
+	}
+
+	var buf bytes.Buffer
+	buf.Write([]byte{rmcpVersion, 0x00, 0xff, rmcpClassIPMI})
+	buf.Write([]byte{authTypeRMCPPlus, payloadType})
+	buf.Write(uint32LE(sessionID))
+	buf.Write(uint32LE(seq))
+	_ = binary.Write(&buf, binary.LittleEndian, uint16(len(payload)))
+	buf.Write(payload)
+
+	if keys != nil {
+		// from the auth type to the next header must be a multiple of 4
+		padLen := (4 - (buf.Len()-4+2)%4) % 4
+		buf.Write(bytes.Repeat([]byte{0xff}, padLen))
+		buf.Write([]byte{byte(padLen), rmcpClassIPMI})
+		buf.Write(hmacSHA1(keys.k1, buf.Bytes()[4:])[:integrityLen])
+	}
+
+	return buf.Bytes(), nil
+}
+
+// decode an RMCP+ packet, verifying and decrypting it if needed.
+func (keys *sessionKeys) decode(packet []byte) (payloadType byte, sessionID uint32, payload []byte, err error) {
+	if len(packet) < 16 || packet[0] != rmcpVersion || packet[3] != rmcpClassIPMI || packet[4] != authTypeRMCPPlus {
+		return 0, 0, nil, errors.New("not an RMCP+ packet")
+	}
+
+	payloadType = packet[5]
+	sessionID = binary.LittleEndian.Uint32(packet[6:10])
+	length := int(binary.LittleEndian.Uint16(packet[14:16]))
+	if len(packet) < 16+length {
+		return 0, 0, nil, errors.New("truncated RMCP+ packet")
+	}
+	payload = packet[16 : 16+length]
+
+	if payloadType&payloadAuthenticated != 0 {
+		if keys == nil || len(packet) < 16+length+2+integrityLen {
+			return 0, 0, nil, errors.New("unexpected authenticated RMCP+ packet")
+		}
+		authCode := packet[len(packet)-integrityLen:]
+		if !hmac.Equal(authCode, hmacSHA1(keys.k1, packet[4:len(packet)-integrityLen])[:integrityLen]) {
+			return 0, 0, nil, errors.New("RMCP+ packet integrity check failed")
+		}
+	}
+
+	if payloadType&payloadEncrypted != 0 {
+		if keys == nil {
+			return 0, 0, nil, errors.New("unexpected encrypted RMCP+ packet")
+		}
+		if payload, err = decryptAESCBC(keys.k2[:16], payload); err != nil {
+			return 0, 0, nil, err
+		}
+	}
+
+	return payloadType &^ (payloadEncrypted | payloadAuthenticated), sessionID, payload, nil
+}
+
+// encryptAESCBC return the IV followed by the encrypted data,
+// padded with 1, 2, 3... and the pad length as the spec requires.
+func encryptAESCBC(key, data []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	padLen := (aes.BlockSize - (len(data)+1)%aes.BlockSize) % aes.BlockSize
+	plain := make([]byte, 0, len(data)+padLen+1)
+	plain = append(plain, data...)
+	for i := 1; i <= padLen; i++ {
+		plain = append(plain, byte(i))
+	}
+	plain = append(plain, byte(padLen))
+
+	out := make([]byte, aes.BlockSize+len(plain))
+	if _, err = rand.Read(out[:aes.BlockSize]); err != nil {
+		return nil, err
+	}
+	cipher.NewCBCEncrypter(block, out[:aes.BlockSize]).CryptBlocks(out[aes.BlockSize:], plain)
+	return out, nil
+}
+
+func decryptAESCBC(key, data []byte) ([]byte, error) {
+	if len(data) < 2*aes.BlockSize || len(data)%aes.BlockSize != 0 {
+		return nil, errors.New("invalid encrypted payload length")
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	plain := make([]byte, len(data)-aes.BlockSize)
+	cipher.NewCBCDecrypter(block, data[:aes.BlockSize]).CryptBlocks(plain, data[aes.BlockSize:])
+
+	padLen := int(plain[len(plain)-1])
+	if padLen >= aes.BlockSize {
+		return nil, errors.New("invalid encrypted payload padding")
+	}
+	return plain[:len(plain)-1-padLen], nil
+}
+
+// checksum is the 2's complement checksum of the ipmi messages.
+func checksum(data []byte) byte {
+	var sum byte
+	for _, b := range data {
+		sum += b
+	}
+	return -sum
+}
+
+// encodeRequest return an ipmi request message.
+func encodeRequest(netFn, cmd, rqSeq byte, data []byte) []byte {
+	msg := []byte{bmcAddr, netFn << 2}
+	msg = append(msg, checksum(msg))
+	msg = append(msg, consoleAddr, rqSeq<<2, cmd)
+	msg = append(msg, data...)
+	return append(msg, checksum(msg[3:]))
+}
+
+// decodeResponse parse an ipmi response message.
+func decodeResponse(msg []byte) (rqSeq, cmd, code byte, data []byte, err error) {
+	if len(msg) < 8 {
+		return 0, 0, 0, nil, errors.New("ipmi response too short")
+	}
+	if checksum(msg[:2]) != msg[2] || checksum(msg[3:len(msg)-1]) != msg[len(msg)-1] {
+		return 0, 0, 0, nil, errors.New("ipmi response checksum mismatch")
+	}
+	return msg[4] >> 2, msg[5], msg[6], msg[7 : len(msg)-1], nil
+}
+
+// exchange send a packet and wait for the matching response.
+func (l *lan) exchange(keys *sessionKeys, payloadType byte, sessionID, seq uint32, payload []byte,
+	match func(payloadType byte, payload []byte) bool) ([]byte, error) {
+
+	packet, err := keys.encode(payloadType, sessionID, seq, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = l.conn.SetDeadline(l.deadline); err != nil {
+		return nil, err
+	}
+	if _, err = l.conn.Write(packet); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 1024)
+	for {
+		n, err := l.conn.Read(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				return nil, fmt.Errorf("%w: no response from BMC %s", cli.ErrTimeout, l.config.Host)
+			}
+			return nil, err
+		}
+
+		// stale or unrelated packets are ignored
+		pt, _, p, err := keys.decode(buf[:n])
+		if err == nil && match(pt, p) {
+			return p, nil
+		}
+	}
+}
+
+// exchangeSessionSetup exchange the session-less open session and RAKP messages.
+func (l *lan) exchangeSessionSetup(payloadType, responseType byte, payload []byte) ([]byte, error) {
+	return l.exchange(nil, payloadType, 0, 0, payload, func(pt byte, p []byte) bool {
+		return pt == responseType && len(p) > 1 && p[0] == payload[0]
+	})
+}
+
+// open the connection and activate a session.
+func (l *lan) open() (err error) {
+	if l.config.Host == "" {
+		return errors.New("the ipmi native backend needs a lan host")
+	}
+
+	privilege := byte(privilegeAdministrator)
+	if l.config.Privilege != "" {
+		var ok bool
+		if privilege, ok = privileges[l.config.Privilege]; !ok {
+			return fmt.Errorf("unknown ipmi privilege `%s`, use user, operator or administrator", l.config.Privilege)
+		}
+	}
+
+	address := net.JoinHostPort(l.config.Host, strconv.Itoa(l.config.Port))
+	if l.conn, err = net.DialTimeout("udp", address, time.Until(l.deadline)); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			l.reset()
+		}
+	}()
+
+	random := make([]byte, 20)
+	if _, err = rand.Read(random); err != nil {
+		return err
+	}
+	l.consoleID = binary.LittleEndian.Uint32(random[:4]) | 1
+	rm := random[4:20]
+
+	// open session
+	req := []byte{0x01, privilege, 0, 0}
+	req = append(req, uint32LE(l.consoleID)...)
+	req = append(req, 0x00, 0, 0, 0x08, algAuthHMACSHA1, 0, 0, 0)
+	req = append(req, 0x01, 0, 0, 0x08, algIntegrityHMACSHA1, 0, 0, 0)
+	req = append(req, 0x02, 0, 0, 0x08, algConfAESCBC128, 0, 0, 0)
+	resp, err := l.exchangeSessionSetup(payloadOpenSessionRequest, payloadOpenSessionResponse, req)
+	if err != nil {
+		return err
+	}
+	if resp[1] != 0 {
+		return fmt.Errorf("ipmi open session refused, status 0x%02x", resp[1])
+	}
+	if len(resp) < 36 {
+		return errors.New("ipmi open session response too short")
+	}
+	l.bmcID = binary.LittleEndian.Uint32(resp[8:12])
+
+	// RAKP 1 & 2
+	username := []byte(l.config.Username)
+	if len(username) > 16 {
+		return errors.New("ipmi username too long, 16 characters max")
+	}
+	role := 0x10 | privilege // name-only lookup
+	req = []byte{0x02, 0, 0, 0}
+	req = append(req, uint32LE(l.bmcID)...)
+	req = append(req, rm...)
+	req = append(req, role, 0, 0, byte(len(username)))
+	req = append(req, username...)
+	if resp, err = l.exchangeSessionSetup(payloadRAKP1, payloadRAKP2, req); err != nil {
+		return err
+	}
+	if resp[1] != 0 {
+		return fmt.Errorf("ipmi authentication failed (RAKP 2 status 0x%02x), check username and password", resp[1])
+	}
+	if len(resp) < 60 {
+		return errors.New("ipmi RAKP 2 message too short")
+	}
+	rc, guid := resp[8:24], resp[24:40]
+
+	// the password is the 20 bytes key Kuid, also used as Kg (BMC key)
+	kuid := make([]byte, 20)
+	copy(kuid, l.config.Password)
+
+	userInfo := append([]byte{role, byte(len(username))}, username...)
+	consoleID, bmcID := uint32LE(l.consoleID), uint32LE(l.bmcID)
+	if !hmac.Equal(resp[40:60], hmacSHA1(kuid, consoleID, bmcID, rm, rc, guid, userInfo)) {
+		return errors.New("ipmi RAKP 2 auth code mismatch, check the password")
+	}
+	sik := hmacSHA1(kuid, rm, rc, userInfo)
+
+	// RAKP 3 & 4
+	req = []byte{0x03, 0, 0, 0}
+	req = append(req, bmcID...)
+	req = append(req, hmacSHA1(kuid, rc, consoleID, userInfo)...)
+	if resp, err = l.exchangeSessionSetup(payloadRAKP3, payloadRAKP4, req); err != nil {
+		return err
+	}
+	if resp[1] != 0 {
+		return fmt.Errorf("ipmi authentication failed (RAKP 4 status 0x%02x)", resp[1])
+	}
+	if len(resp) < 8+integrityLen || !hmac.Equal(resp[8:8+integrityLen], hmacSHA1(sik, rm, bmcID, guid)[:integrityLen]) {
+		return errors.New("ipmi RAKP 4 integrity check failed")
+	}
+
+	l.keys = &sessionKeys{
+		k1: hmacSHA1(sik, bytes.Repeat([]byte{0x01}, 20)),
+		k2: hmacSHA1(sik, bytes.Repeat([]byte{0x02}, 20)),
+	}
+	l.seq = 0
+
+	// sessions start at user level
+	_, err = l.request(netFnApp, cmdSetSessionPrivilege, privilege)
+	return err
+}
+
+// request send an ipmi request in the active session.
+func (l *lan) request(netFn, cmd byte, data ...byte) ([]byte, error) {
+	l.seq++
+	l.rqSeq = (l.rqSeq + 1) & 0x3f
+	rqSeq := l.rqSeq
+
+	var code byte
+	var respData []byte
+	_, err := l.exchange(l.keys, payloadIPMI, l.bmcID, l.seq, encodeRequest(netFn, cmd, rqSeq, data),
+		func(pt byte, p []byte) bool {
+			seq, respCmd, c, d, err := decodeResponse(p)
+			if pt != payloadIPMI || err != nil || seq != rqSeq || respCmd != cmd {
+				return false
+			}
+			code, respData = c, d
+			return true
+		})
+	if err != nil {
+		return nil, err
+	}
+	if code != 0 {
+		return nil, completionError{netFn: netFn, cmd: cmd, code: code}
+	}
+	return respData, nil
+}
+
+// do send a request opening the session if needed,
+// a lost session is reopened once.
+// The timeout is split across the attempts,
+// so that a request never takes longer than the timeout.
+func (l *lan) do(netFn, cmd byte, data ...byte) ([]byte, error) {
+	deadline := time.Now().Add(l.timeout)
+
+	if l.keys == nil {
+		l.deadline = deadline
+		if err := l.open(); err != nil {
+			return nil, err
+		}
+		return l.request(netFn, cmd, data...)
+	}
+
+	// half of the timeout to the reused session,
+	// the rest to reopen it if lost
+	l.deadline = time.Now().Add(l.timeout / 2)
+	resp, err := l.request(netFn, cmd, data...)
+	if _, ok := err.(completionError); err == nil || ok {
+		return resp, err
+	}
+
+	l.reset()
+	l.deadline = deadline
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l.request(netFn, cmd, data...)
+}
+
+// reset drop the session without closing it on the BMC.
+func (l *lan) reset() {
+	if l.conn != nil {
+		_ = l.conn.Close()
+	}
+	l.conn = nil
+	l.keys = nil
+	l.sensors = nil
+}
+
+func (l *lan) raw(netFn, cmd byte, data ...byte) ([]byte, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.do(netFn, cmd, data...)
+}
+
+func (l *lan) close() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.keys != nil {
+		l.deadline = time.Now().Add(l.timeout)
+		_, _ = l.request(netFnApp, cmdCloseSession, uint32LE(l.bmcID)...)
+	}
+	l.reset()
+}
@@ -0,0 +1,368 @@
+package ipmi
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+const (
+	cmdGetSensorReading     = 0x2d
+	cmdSetSensorThresholds  = 0x26
+	cmdReserveSDRRepository = 0x22
+	cmdGetSDR               = 0x23
+
+	sdrFullSensorRecord = 0x01
+	sensorTypeFan       = 0x04
+	sdrHeaderLen        = 5
+	sdrChunkLen         = 16
+	sdrLastRecord       = 0xffff
+
+	completionReservationCanceled = 0xc5
+)
+
+// unitNames are the sdr base units names, as printed by ipmitool.
+var unitNames = map[byte]string{
+	1:  "degrees C",
+	2:  "degrees F",
+	4:  "Volts",
+	5:  "Amps",
+	6:  "Watts",
+	18: "RPM",
+}
+
+// sensorRecord is an sdr full sensor record,
+// with the factors to convert the raw readings.
+type sensorRecord struct {
+	name           string
+	owner          byte
+	number         byte
+	entityID       byte
+	entityInstance byte
+	sensorType     byte
+	// analog data format: 0 unsigned, 1 1's complement, 2 2's complement, 3 no analog reading
+	format        byte
+	unit          byte
+	linearization byte
+	m, b          int
+	bExp, rExp    int
+}
+
+// signed return the n bits value v as a 2's complement signed int.
+func signed(v int, n uint) int {
+	if v&(1<<(n-1)) != 0 {
+		return v - 1<<n
+	}
+	return v
+}
+
+// parseFullSensorRecord parse a full sensor record, header included.
+func parseFullSensorRecord(data []byte) (sensorRecord, error) {
+	if len(data) < 48 || data[3] != sdrFullSensorRecord {
+		return sensorRecord{}, errors.New("not a full sensor record")
+	}
+
+	nameLen := int(data[47] & 0x1f)
+	if len(data) < 48+nameLen {
+		return sensorRecord{}, errors.New("truncated full sensor record")
+	}
+
+	return sensorRecord{
+		name:           string(data[48 : 48+nameLen]),
+		owner:          data[5],
+		number:         data[7],
+		entityID:       data[8],
+		entityInstance: data[9] & 0x7f,
+		sensorType:     data[12],
+		format:         data[20] >> 6,
+		unit:           data[21],
+		linearization:  data[23] & 0x7f,
+		m:              signed(int(data[24])|int(data[25]>>6)<<8, 10),
+		b:              signed(int(data[26])|int(data[27]>>6)<<8, 10),
+		rExp:           signed(int(data[29]>>4), 4),
+		bExp:           signed(int(data[29]&0x0f), 4),
+	}, nil
+}
+
+// convert a raw reading: y = L[(M*x + B*10^bExp) * 10^rExp].
+func (r sensorRecord) convert(raw byte) float64 {
+	x := float64(raw)
+	switch r.format {
+	case 1:
+		x = float64(int8(raw))
+		if raw&0x80 != 0 {
+			x++
+		}
+	case 2:
+		x = float64(int8(raw))
+	}
+
+	y := (float64(r.m)*x + float64(r.b)*math.Pow10(r.bExp)) * math.Pow10(r.rExp)
+
+	switch r.linearization {
+	case 1:
+		y = math.Log(y)
+	case 2:
+		y = math.Log10(y)
+	case 3:
+		y = math.Log2(y)
+	case 4:
+		y = math.Exp(y)
+	case 5:
+		y = math.Pow(10, y)
+	case 6:
+		y = math.Exp2(y)
+	case 7:
+		y = 1 / y
+	case 8:
+		y = y * y
+	case 9:
+		y = y * y * y
+	case 10:
+		y = math.Sqrt(y)
+	case 11:
+		y = math.Cbrt(y)
+	}
+
+	return math.Round(y*1000) / 1000
+}
+
+// toRaw is the inverse of convert, for linear sensors only.
+func (r sensorRecord) toRaw(y float64) (byte, error) {
+	if r.linearization != 0 || r.m == 0 {
+		return 0, fmt.Errorf("sensor %s is not linear", r.name)
+	}
+
+	x := math.Round((y/math.Pow10(r.rExp) - float64(r.b)*math.Pow10(r.bExp)) / float64(r.m))
+
+	min, max := 0.0, 255.0
+	if r.format == 1 || r.format == 2 {
+		min, max = -127, 127
+	}
+	if x < min || x > max {
+		return 0, fmt.Errorf("value %v out of the range of sensor %s", y, r.name)
+	}
+	return byte(int8(x)), nil
+}
+
+func (r sensorRecord) entity() string {
+	return fmt.Sprintf("%d.%d", r.entityID, r.entityInstance)
+}
+
+// readSensors read the full sensor records of the sdr repository, if needed.
+func (l *lan) readSensors() error {
+	if l.sensors != nil {
+		return nil
+	}
+
+	sensors := make([]sensorRecord, 0)
+	for id := uint16(0); id != sdrLastRecord; {
+		record, next, err := l.readSDR(id)
+		if err != nil {
+			return err
+		}
+
+		// sensors owned by other controllers would need bridging
+		if sensor, err := parseFullSensorRecord(record); err == nil && sensor.owner == bmcAddr {
+			sensors = append(sensors, sensor)
+		}
+
+		if next == id {
+			break
+		}
+		id = next
+	}
+
+	l.sensors = sensors
+	return nil
+}
+
+// readSDR read a whole sdr record in chunks, returning the next record id.
+func (l *lan) readSDR(id uint16) (record []byte, next uint16, err error) {
+	for retry := 0; retry < 3; retry++ {
+		if record, next, err = l.readSDRReserved(id); err == nil {
+			return
+		}
+		if ce, ok := err.(completionError); !ok || ce.code != completionReservationCanceled {
+			return
+		}
+	}
+	return
+}
+
+func (l *lan) readSDRReserved(id uint16) (record []byte, next uint16, err error) {
+	resp, err := l.do(netFnStorage, cmdReserveSDRRepository)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(resp) < 2 {
+		return nil, 0, errors.New("invalid sdr reservation response")
+	}
+	reservation := resp[:2]
+
+	read := func(offset, count int) ([]byte, error) {
+		req := append([]byte{}, reservation...)
+		req = append(req, byte(id), byte(id>>8), byte(offset), byte(count))
+		resp, err := l.do(netFnStorage, cmdGetSDR, req...)
+		if err != nil {
+			return nil, err
+		}
+		if len(resp) < 2 {
+			return nil, errors.New("invalid sdr response")
+		}
+		next = binary.LittleEndian.Uint16(resp[:2])
+		return resp[2:], nil
+	}
+
+	if record, err = read(0, sdrHeaderLen); err != nil {
+		return nil, 0, err
+	}
+	if len(record) < sdrHeaderLen {
+		return nil, 0, errors.New("invalid sdr record header")
+	}
+
+	length := sdrHeaderLen + int(record[4])
+	for len(record) < length {
+		count := length - len(record)
+		if count > sdrChunkLen {
+			count = sdrChunkLen
+		}
+		chunk, err := read(len(record), count)
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(chunk) == 0 {
+			return nil, 0, errors.New("empty sdr record chunk")
+		}
+		record = append(record, chunk...)
+	}
+
+	return record, next, nil
+}
+
+// reading return the sensor status and reading, as printed by `ipmitool sdr elist`.
+func (l *lan) reading(sensor sensorRecord) (status, reading string, err error) {
+	resp, err := l.do(netFnSensor, cmdGetSensorReading, sensor.number)
+	if err != nil {
+		return "", "", err
+	}
+	if len(resp) < 2 {
+		return "", "", errors.New("invalid sensor reading response")
+	}
+
+	if resp[1]&0x40 == 0 {
+		return "ns", "disabled", nil
+	}
+	if resp[1]&0x20 != 0 || sensor.format == 3 {
+		return "ns", "no reading", nil
+	}
+
+	status = "ok"
+	if len(resp) > 2 {
+		switch {
+		case resp[2]&0x24 != 0:
+			status = "nr"
+		case resp[2]&0x12 != 0:
+			status = "cr"
+		case resp[2]&0x09 != 0:
+			status = "nc"
+		}
+	}
+
+	reading = strconv.FormatFloat(sensor.convert(resp[0]), 'f', -1, 64)
+	if unit, ok := unitNames[sensor.unit]; ok {
+		reading += " " + unit
+	}
+	return status, reading, nil
+}
+
+func (l *lan) sdrElist(entityID string) ([]SDRRecord, error) {
+	return l.records(func(sensor sensorRecord) bool {
+		return entityID == "" || sensor.entity() == entityID
+	})
+}
+
+// sdrElistFull return all the records, only the full
+// sensor records are read by the native backend.
+func (l *lan) sdrElistFull() ([]SDRRecord, error) {
+	return l.sdrElist("")
+}
+
+func (l *lan) sdrFans() ([]SDRRecord, error) {
+	return l.records(func(sensor sensorRecord) bool {
+		return sensor.sensorType == sensorTypeFan
+	})
+}
+
+// records return the sdr records of the sensors matching filter.
+func (l *lan) records(filter func(sensor sensorRecord) bool) ([]SDRRecord, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if err := l.readSensors(); err != nil {
+		return nil, err
+	}
+
+	records := make([]SDRRecord, 0)
+	for _, sensor := range l.sensors {
+		if !filter(sensor) {
+			continue
+		}
+
+		status, reading, err := l.reading(sensor)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, newSDRRecord(sensor.name, fmt.Sprintf("%02Xh", sensor.number), status, sensor.entity(), reading))
+	}
+	return records, nil
+}
+
+func (l *lan) setThresholds(name string, upper bool, values []string) error {
+	if len(values) != 3 {
+		return errors.New("thresholds must have three values")
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if err := l.readSensors(); err != nil {
+		return err
+	}
+
+	for _, sensor := range l.sensors {
+		if sensor.name != name {
+			continue
+		}
+
+		raw := make([]byte, len(values))
+		for i, v := range values {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return fmt.Errorf("invalid threshold `%s` for sensor %s", v, name)
+			}
+			if raw[i], err = sensor.toRaw(f); err != nil {
+				return err
+			}
+		}
+
+		// sensor, mask, lower nc, lower c, lower nr, upper nc, upper c, upper nr
+		req := make([]byte, 8)
+		req[0] = sensor.number
+		if upper {
+			req[1] = 0x38
+			copy(req[5:], raw)
+		} else {
+			// lower values are Non-Recoverable, Critical, Non-Critical
+			req[1] = 0x07
+			req[2], req[3], req[4] = raw[2], raw[1], raw[0]
+		}
+
+		_, err := l.do(netFnSensor, cmdSetSensorThresholds, req...)
+		return err
+	}
+
+	return fmt.Errorf("no such sensor: %s", name)
+}
@@ -0,0 +1,398 @@
+package ipmi
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"encoding/binary"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeBMC is a minimal RMCP+ responder, cipher suite 3 only.
+type fakeBMC struct {
+	t        *testing.T
+	conn     *net.UDPConn
+	username string
+	password string
+
+	mutex        sync.Mutex
+	sessions     int
+	closed       int
+	keys         *sessionKeys
+	consoleID    uint32
+	rm, rc       []byte
+	userInfo     []byte
+	manufacturer uint32
+	fanMode      byte
+	duty         map[byte]byte
+	records      [][]byte
+	readings     map[byte]byte
+	thresholds   [][]byte
+}
+
+var fakeGUID = bytes.Repeat([]byte{0x42}, 16)
+
+const fakeBMCID = 0x0a0b0c0d
+
+func newFakeBMC(t *testing.T) *fakeBMC {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+
+	bmc := &fakeBMC{
+		t:            t,
+		conn:         conn,
+		username:     "ADMIN",
+		password:     "secret",
+		manufacturer: 10876,
+		fanMode:      0x01,
+		duty:         map[byte]byte{0x00: 30, 0x01: 40},
+		records: [][]byte{
+			fullSensorRecord(0x0001, 0x01, 3, 1, 1, 1, 0, 0, 0, "CPU Temp"),
+			fullSensorRecord(0x0002, 0x41, 29, 1, 18, 100, 0, 0, 0, "FAN1"),
+			fullSensorRecord(0x0003, 0x30, 7, 1, 4, 6, 0, 0, -2, "12V"),
+		},
+		readings: map[byte]byte{0x01: 45, 0x41: 12, 0x30: 201},
+	}
+	go bmc.serve()
+	return bmc
+}
+
+func (bmc *fakeBMC) config() lanConfig {
+	return lanConfig{
+		Host:     "127.0.0.1",
+		Port:     bmc.conn.LocalAddr().(*net.UDPAddr).Port,
+		Username: bmc.username,
+		Password: bmc.password,
+	}
+}
+
+// forget the active session, as a rebooted BMC would do.
+func (bmc *fakeBMC) forget() {
+	bmc.mutex.Lock()
+	defer bmc.mutex.Unlock()
+	bmc.keys = nil
+}
+
+func fullSensorRecord(id uint16, number, entityID, instance, unit byte, m, b, bExp, rExp int, name string) []byte {
+	record := make([]byte, 48+len(name))
+	binary.LittleEndian.PutUint16(record, id)
+	record[2] = 0x51
+	record[3] = sdrFullSensorRecord
+	record[4] = byte(len(record) - sdrHeaderLen)
+	record[5] = bmcAddr
+	record[7] = number
+	record[8] = entityID
+	record[9] = instance
+	record[12] = map[byte]byte{1: 0x01, 4: 0x02, 18: sensorTypeFan}[unit]
+	record[21] = unit
+	record[24], record[25] = byte(m), byte(m>>8&0x03)<<6
+	record[26], record[27] = byte(b), byte(b>>8&0x03)<<6
+	record[29] = byte(rExp&0x0f)<<4 | byte(bExp&0x0f)
+	record[47] = 0xc0 | byte(len(name))
+	copy(record[48:], name)
+	return record
+}
+
+func (bmc *fakeBMC) serve() {
+	buf := make([]byte, 1024)
+	for {
+		n, addr, err := bmc.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+
+		bmc.mutex.Lock()
+		if resp := bmc.handle(buf[:n]); resp != nil {
+			_, _ = bmc.conn.WriteToUDP(resp, addr)
+		}
+		bmc.mutex.Unlock()
+	}
+}
+
+func (bmc *fakeBMC) handle(packet []byte) []byte {
+	payloadType, _, p, err := bmc.keys.decode(packet)
+	if err != nil {
+		return nil
+	}
+
+	kuid := make([]byte, 20)
+	copy(kuid, bmc.password)
+
+	setup := func(payloadType byte, payload []byte) []byte {
+		out, err := (*sessionKeys)(nil).encode(payloadType, 0, 0, payload)
+		require.NoError(bmc.t, err)
+		return out
+	}
+
+	switch payloadType {
+	case payloadOpenSessionRequest:
+		bmc.sessions++
+		bmc.consoleID = binary.LittleEndian.Uint32(p[4:8])
+		resp := []byte{p[0], 0, privilegeAdministrator, 0}
+		resp = append(resp, p[4:8]...)
+		resp = append(resp, uint32LE(fakeBMCID)...)
+		return setup(payloadOpenSessionResponse, append(resp, p[8:32]...))
+
+	case payloadRAKP1:
+		bmc.rm = append([]byte{}, p[8:24]...)
+		bmc.rc = bytes.Repeat([]byte{0x24}, 16)
+		bmc.userInfo = append([]byte{p[24]}, p[27:28+p[27]]...)
+		if string(p[28:28+p[27]]) != bmc.username {
+			return setup(payloadRAKP2, []byte{p[0], 0x0d, 0, 0})
+		}
+		resp := []byte{p[0], 0, 0, 0}
+		resp = append(resp, uint32LE(bmc.consoleID)...)
+		resp = append(resp, bmc.rc...)
+		resp = append(resp, fakeGUID...)
+		resp = append(resp, hmacSHA1(kuid, uint32LE(bmc.consoleID), uint32LE(fakeBMCID), bmc.rm, bmc.rc, fakeGUID, bmc.userInfo)...)
+		return setup(payloadRAKP2, resp)
+
+	case payloadRAKP3:
+		if !hmac.Equal(p[8:28], hmacSHA1(kuid, bmc.rc, uint32LE(bmc.consoleID), bmc.userInfo)) {
+			return setup(payloadRAKP4, []byte{p[0], 0x0f, 0, 0})
+		}
+		sik := hmacSHA1(kuid, bmc.rm, bmc.rc, bmc.userInfo)
+		resp := []byte{p[0], 0, 0, 0}
+		resp = append(resp, uint32LE(bmc.consoleID)...)
+		resp = append(resp, hmacSHA1(sik, bmc.rm, uint32LE(fakeBMCID), fakeGUID)[:integrityLen]...)
+		out := setup(payloadRAKP4, resp)
+		bmc.keys = &sessionKeys{
+			k1: hmacSHA1(sik, bytes.Repeat([]byte{0x01}, 20)),
+			k2: hmacSHA1(sik, bytes.Repeat([]byte{0x02}, 20)),
+		}
+		return out
+
+	case payloadIPMI:
+		if bmc.keys == nil || packet[5]&payloadAuthenticated == 0 {
+			return nil
+		}
+		netFn, rqSeq, cmd, data := p[1]>>2, p[4]>>2, p[5], p[6:len(p)-1]
+		code, respData := bmc.command(netFn, cmd, data)
+
+		msg := []byte{consoleAddr, (netFn | 1) << 2}
+		msg = append(msg, checksum(msg))
+		msg = append(msg, bmcAddr, rqSeq<<2, cmd, code)
+		msg = append(msg, respData...)
+		msg = append(msg, checksum(msg[3:]))
+
+		out, err := bmc.keys.encode(payloadIPMI, bmc.consoleID, 1, msg)
+		require.NoError(bmc.t, err)
+		if netFn == netFnApp && cmd == cmdCloseSession {
+			bmc.closed++
+			bmc.keys = nil
+		}
+		return out
+	}
+	return nil
+}
+
+func (bmc *fakeBMC) command(netFn, cmd byte, data []byte) (code byte, resp []byte) {
+	switch {
+	case netFn == netFnApp && cmd == cmdSetSessionPrivilege:
+		return 0, data[:1]
+	case netFn == netFnApp && cmd == cmdCloseSession:
+		return 0, nil
+	case netFn == netFnApp && cmd == cmdGetDeviceID:
+		return 0, []byte{0x20, 0x01, 0x03, 0x88, 0x02, 0xbf,
+			byte(bmc.manufacturer), byte(bmc.manufacturer >> 8), byte(bmc.manufacturer >> 16), 0x73, 0x08}
+	case netFn == 0x30 && cmd == 0x45 && data[0] == 0x00:
+		return 0, []byte{bmc.fanMode}
+	case netFn == 0x30 && cmd == 0x45 && data[0] == 0x01:
+		bmc.fanMode = data[1]
+		return 0, nil
+	case netFn == 0x30 && cmd == 0x70 && data[1] == 0x00:
+		return 0, []byte{bmc.duty[data[2]]}
+	case netFn == 0x30 && cmd == 0x70 && data[1] == 0x01:
+		bmc.duty[data[2]] = data[3]
+		return 0, nil
+	case netFn == netFnStorage && cmd == cmdReserveSDRRepository:
+		return 0, []byte{0x01, 0x00}
+	case netFn == netFnStorage && cmd == cmdGetSDR:
+		id := int(binary.LittleEndian.Uint16(data[2:4]))
+		if id == 0 {
+			id = 1
+		}
+		if id > len(bmc.records) {
+			return 0xcb, nil
+		}
+		record := bmc.records[id-1]
+		next := uint16(id + 1)
+		if id == len(bmc.records) {
+			next = sdrLastRecord
+		}
+		offset, count := int(data[4]), int(data[5])
+		if count > sdrChunkLen {
+			return 0xca, nil
+		}
+		if offset+count > len(record) {
+			count = len(record) - offset
+		}
+		resp = make([]byte, 2)
+		binary.LittleEndian.PutUint16(resp, next)
+		return 0, append(resp, record[offset:offset+count]...)
+	case netFn == netFnSensor && cmd == cmdGetSensorReading:
+		return 0, []byte{bmc.readings[data[0]], 0xc0, 0x00}
+	case netFn == netFnSensor && cmd == cmdSetSensorThresholds:
+		bmc.thresholds = append(bmc.thresholds, append([]byte{}, data...))
+		return 0, nil
+	}
+	return 0xc1, nil
+}
+
+func TestLAN(t *testing.T) {
+	bmc := newFakeBMC(t)
+	defer bmc.conn.Close()
+
+	l := newLAN(bmc.config(), time.Second)
+
+	resp, err := l.raw(0x30, 0x45, 0x00)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x01}, resp)
+
+	_, err = l.raw(0x30, 0x70, 0x66, 0x01, 0x00, 50)
+	require.NoError(t, err)
+	resp, err = l.raw(0x30, 0x70, 0x66, 0x00, 0x00)
+	require.NoError(t, err)
+	require.Equal(t, []byte{50}, resp)
+
+	_, err = l.raw(0x30, 0x99)
+	require.Equal(t, completionError{netFn: 0x30, cmd: 0x99, code: 0xc1}, err)
+
+	records, err := l.sdrElist("")
+	require.NoError(t, err)
+	require.Equal(t, []SDRRecord{
+		{Name: "CPU Temp", SensorID: "01h", Status: "ok", EntityID: "3.1", Reading: "45 degrees C", Value: 45, Unit: "degrees C"},
+		{Name: "FAN1", SensorID: "41h", Status: "ok", EntityID: "29.1", Reading: "1200 RPM", Value: 1200, Unit: "RPM"},
+		{Name: "12V", SensorID: "30h", Status: "ok", EntityID: "7.1", Reading: "12.06 Volts", Value: 12.06, Unit: "Volts"},
+	}, records)
+
+	records, err = l.sdrElist("29.1")
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	require.Equal(t, "FAN1", records[0].Name)
+
+	require.NoError(t, l.setThresholds("FAN1", false, []string{"0", "300", "500"}))
+	require.NoError(t, l.setThresholds("FAN1", true, []string{"4900", "5000", "5100"}))
+	require.Error(t, l.setThresholds("FAN9", true, []string{"4900", "5000", "5100"}))
+
+	bmc.mutex.Lock()
+	require.Equal(t, [][]byte{
+		{0x41, 0x07, 5, 3, 0, 0, 0, 0},
+		{0x41, 0x38, 0, 0, 0, 49, 50, 51},
+	}, bmc.thresholds)
+	require.Equal(t, 1, bmc.sessions, "session reused")
+	bmc.mutex.Unlock()
+
+	l.close()
+
+	bmc.mutex.Lock()
+	require.Equal(t, 1, bmc.closed)
+	bmc.mutex.Unlock()
+}
+
+func TestLAN_reopen(t *testing.T) {
+	bmc := newFakeBMC(t)
+	defer bmc.conn.Close()
+
+	l := newLAN(bmc.config(), 200*time.Millisecond)
+	defer l.close()
+
+	_, err := l.raw(0x30, 0x45, 0x00)
+	require.NoError(t, err)
+
+	bmc.forget()
+
+	resp, err := l.raw(0x30, 0x45, 0x00)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x01}, resp)
+
+	bmc.mutex.Lock()
+	require.Equal(t, 2, bmc.sessions)
+	bmc.mutex.Unlock()
+}
+
+func TestLAN_timeout(t *testing.T) {
+	bmc := newFakeBMC(t)
+	defer bmc.conn.Close()
+
+	l := newLAN(bmc.config(), 200*time.Millisecond)
+	defer l.close()
+
+	_, err := l.raw(0x30, 0x45, 0x00)
+	require.NoError(t, err)
+
+	// the BMC stops answering, the resend shares the same timeout
+	port := bmc.config().Port
+	require.NoError(t, bmc.conn.Close())
+	silent, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port})
+	require.NoError(t, err)
+	defer silent.Close()
+
+	start := time.Now()
+	_, err = l.raw(0x30, 0x45, 0x00)
+	require.Error(t, err)
+	require.Less(t, int64(time.Since(start)), int64(300*time.Millisecond))
+}
+
+func TestLAN_authentication(t *testing.T) {
+	bmc := newFakeBMC(t)
+	defer bmc.conn.Close()
+
+	config := bmc.config()
+	config.Password = "wrong"
+	_, err := newLAN(config, time.Second).raw(0x30, 0x45, 0x00)
+	require.Error(t, err)
+
+	config = bmc.config()
+	config.Username = "nobody"
+	_, err = newLAN(config, time.Second).raw(0x30, 0x45, 0x00)
+	require.Error(t, err)
+}
+
+func TestIPMI_native(t *testing.T) {
+	bmc := newFakeBMC(t)
+	defer bmc.conn.Close()
+
+	ipmi := &IPMI{Config: Config{Vendor: VendorSupermicroX11}, backend: newLAN(bmc.config(), time.Second), zonesDutyCycles: make(map[uint8]uint8)}
+	defer ipmi.Close()
+
+	temp, err := ipmi.GetTemp("3.1")
+	require.NoError(t, err)
+	require.Equal(t, 45.0, temp)
+
+	_, err = ipmi.GetTemp("9.9")
+	require.Error(t, err)
+
+	require.Equal(t, string(FanModeFull), ipmi.GetFanMode())
+
+	require.NoError(t, ipmi.SetChannelDutyCycle(0x01, 70))
+	dc, err := ipmi.GetChannelDutyCycle(0x01)
+	require.NoError(t, err)
+	require.Equal(t, uint8(70), dc)
+}
+
+func Test_sensorRecord(t *testing.T) {
+	r, err := parseFullSensorRecord(fullSensorRecord(1, 0x10, 3, 2, 1, -2, 511, 1, -1, "Temp"))
+	require.NoError(t, err)
+	require.Equal(t, "Temp", r.name)
+	require.Equal(t, "3.2", r.entity())
+	require.Equal(t, -2, r.m)
+	require.Equal(t, 511, r.b)
+
+	// (-2*10 + 511*10) * 0.1
+	require.Equal(t, 509.0, r.convert(10))
+
+	raw, err := r.toRaw(509)
+	require.NoError(t, err)
+	require.Equal(t, byte(10), raw)
+
+	_, err = r.toRaw(1000)
+	require.Error(t, err)
+
+	r.format = 2
+	require.Equal(t, 511.2, r.convert(0xff))
+}
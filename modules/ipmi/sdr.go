@@ -0,0 +1,292 @@
+package ipmi
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	unitCelsius    = "degrees C"
+	unitFahrenheit = "degrees F"
+	unitRPM        = "RPM"
+)
+
+// SDRRecord is a row of the `sdr elist` output:
+// `CPU Temp | 01h | ok | 3.1 | 45 degrees C`
+type SDRRecord struct {
+	Name     string
+	SensorID string
+	// Status is the sdr status: ok, nc (non-critical), cr (critical),
+	// nr (non-recoverable) or ns (no reading or disabled).
+	Status   string
+	EntityID string
+	// Reading is the raw reading column, eg.: `45 degrees C`, `No Reading`, `0x01`.
+	Reading string
+
+	// Value and Unit are parsed from Reading,
+	// Unit is empty for the discrete and missing readings.
+	Value float64
+	Unit  string
+}
+
+func newSDRRecord(name, sensorID, status, entityID, reading string) SDRRecord {
+	record := SDRRecord{
+		Name:     name,
+		SensorID: sensorID,
+		Status:   status,
+		EntityID: entityID,
+		Reading:  reading,
+	}
+
+	// eg.: `45 degrees C`
+	fields := strings.SplitN(reading, " ", 2)
+	if len(fields) == 2 {
+		if value, err := strconv.ParseFloat(fields[0], 64); err == nil {
+			record.Value = value
+			record.Unit = strings.TrimSpace(fields[1])
+		}
+	}
+	return record
+}
+
+// StatusError is returned reading a sensor without a reading,
+// eg.: `no reading` or `disabled`.
+type StatusError struct {
+	Sensor  string
+	Status  string
+	Reading string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("ipmi sensor %s has no valid reading: %s (%s)", e.Sensor, e.Reading, e.Status)
+}
+
+// UnitError is returned reading a temperature from a sensor of another unit.
+type UnitError struct {
+	Sensor string
+	Unit   string
+}
+
+func (e *UnitError) Error() string {
+	unit := e.Unit
+	if unit == "" {
+		unit = "discrete"
+	}
+	return fmt.Sprintf("ipmi sensor %s is not a temperature sensor: %s", e.Sensor, unit)
+}
+
+// temperature return the record reading in °C.
+// A critical status still returns the temperature, only ns is an error.
+func (r SDRRecord) temperature() (float64, error) {
+	if r.Status == "ns" {
+		return 0, &StatusError{Sensor: r.Name, Status: r.Status, Reading: r.Reading}
+	}
+
+	switch r.Unit {
+	case unitCelsius:
+		return r.Value, nil
+	case unitFahrenheit:
+		return (r.Value - 32) * 5 / 9, nil
+	default:
+		return 0, &UnitError{Sensor: r.Name, Unit: r.Unit}
+	}
+}
+
+func (r SDRRecord) isTemperature() bool {
+	return r.Unit == unitCelsius || r.Unit == unitFahrenheit
+}
+
+var entityIDRegexp = regexp.MustCompile(`^\d+\.\d+$`)
+
+// sensorSelector select a sensor in the `sdr elist` records,
+// parsed from the GetTemp arg:
+// an entity ID (`3.1`), its first temperature sensor,
+// an entity ID and the index of its sensor (`3.1:1`, zero based),
+// or a sensor name (`CPU Temp`).
+type sensorSelector struct {
+	entityID string
+	index    int
+	name     string
+}
+
+func parseSensorSelector(arg string) sensorSelector {
+	arg = strings.TrimSpace(arg)
+
+	if i := strings.LastIndex(arg, ":"); i > 0 && entityIDRegexp.MatchString(arg[:i]) {
+		if index, err := strconv.Atoi(arg[i+1:]); err == nil && index >= 0 {
+			return sensorSelector{entityID: arg[:i], index: index}
+		}
+	}
+
+	if entityIDRegexp.MatchString(arg) {
+		return sensorSelector{entityID: arg, index: -1}
+	}
+
+	return sensorSelector{name: arg, index: -1}
+}
+
+// selectSensor return the selected record.
+func (s sensorSelector) selectSensor(records []SDRRecord) (SDRRecord, error) {
+	if s.name != "" {
+		for _, record := range records {
+			if record.Name == s.name {
+				return record, nil
+			}
+		}
+		return SDRRecord{}, fmt.Errorf("ipmi sensor not found: %s", s.name)
+	}
+
+	entity := make([]SDRRecord, 0)
+	for _, record := range records {
+		if record.EntityID == s.entityID {
+			entity = append(entity, record)
+		}
+	}
+	if len(entity) == 0 {
+		return SDRRecord{}, fmt.Errorf("ipmi entityID not found: %s", s.entityID)
+	}
+
+	if s.index >= 0 {
+		if s.index >= len(entity) {
+			return SDRRecord{}, fmt.Errorf("ipmi entityID %s has %d sensors, no sensor at index %d",
+				s.entityID, len(entity), s.index)
+		}
+		return entity[s.index], nil
+	}
+
+	for _, record := range entity {
+		if record.isTemperature() {
+			return record, nil
+		}
+	}
+	// no temperature sensor, the first one explains why
+	return entity[0], nil
+}
+
+// parseSDRElist parse the `sdr elist` output, malformed rows are skipped.
+func parseSDRElist(out string) []SDRRecord {
+	records := make([]SDRRecord, 0)
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Split(line, "|")
+		if len(fields) != 5 {
+			continue
+		}
+		for i := range fields {
+			fields[i] = strings.TrimSpace(fields[i])
+		}
+		records = append(records, newSDRRecord(fields[0], fields[1], fields[2], fields[3], fields[4]))
+	}
+	return records
+}
+
+// defaultSDRCacheTTL is the sdr cache lifetime, short enough
+// to read the sensors once per check with any check_interval.
+const defaultSDRCacheTTL = time.Second
+
+// sdrCache is the whole `sdr elist` shared by the GetTemp calls of a check,
+// read once per ttl, errors included, so that the controllers
+// reading the same BMC concurrently wait for the same read.
+type sdrCache struct {
+	mutex sync.Mutex
+	ttl   time.Duration
+
+	records []SDRRecord
+	err     error
+	read    time.Time
+}
+
+// get return the cached records, reading them with read when expired.
+func (c *sdrCache) get(read func() ([]SDRRecord, error)) ([]SDRRecord, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.read.IsZero() || time.Since(c.read) >= c.ttl {
+		c.records, c.err = read()
+		c.read = time.Now()
+	}
+	return c.records, c.err
+}
+
+// sdrElist return the `sdr elist` records, through the cache if enabled,
+// the cached records are not filtered by entityID. Both list all the
+// record types, so that a selector picks the same sensor either way.
+func (ipmi *IPMI) sdrElist(entityID string) ([]SDRRecord, error) {
+	b, cache := ipmi.current()
+	if cache == nil {
+		return b.sdrElist(entityID)
+	}
+	return cache.get(func() ([]SDRRecord, error) {
+		return b.sdrElist("")
+	})
+}
+
+// FanReading is a fan tachometer reading.
+type FanReading struct {
+	Name string
+	RPM  uint16
+	// Status is the sdr status, RPM is 0 for ns.
+	Status string
+}
+
+// parseFanReadings return the readings of the rpm records by name,
+// other records (eg.: `Fan Redundancy`) are skipped.
+func parseFanReadings(records []SDRRecord) map[string]FanReading {
+	fans := make(map[string]FanReading)
+	for _, record := range records {
+		switch {
+		case record.Status == "ns":
+			fans[record.Name] = FanReading{Name: record.Name, Status: record.Status}
+		case record.Unit == unitRPM:
+			fans[record.Name] = FanReading{Name: record.Name, RPM: uint16(math.Round(record.Value)), Status: record.Status}
+		}
+	}
+	return fans
+}
+
+// FanReadings return the fans tachometers readings (`sdr type Fan`) by sensor name.
+func (ipmi *IPMI) FanReadings() (map[string]FanReading, error) {
+	b, _ := ipmi.current()
+	records, err := b.sdrFans()
+	if err != nil {
+		return nil, err
+	}
+	return parseFanReadings(records), nil
+}
+
+// fanReader interface implementation,
+// the fans without a reading (`ns`) are reported at 0 rpm,
+// so that a dead fan is flagged by the stall detection.
+func (ipmi *IPMI) GetFanRPMs() (map[string]uint16, error) {
+	fans, err := ipmi.FanReadings()
+	if err != nil {
+		return nil, err
+	}
+
+	rpms := make(map[string]uint16)
+	for name, fan := range fans {
+		rpms[name] = fan.RPM
+	}
+	return rpms, nil
+}
+
+// TempSensors return the full sensor records reading a temperature.
+func (ipmi *IPMI) TempSensors() ([]SDRRecord, error) {
+	b, _ := ipmi.current()
+	records, err := b.sdrElistFull()
+	if err != nil {
+		return nil, err
+	}
+
+	sensors := make([]SDRRecord, 0)
+	for _, record := range records {
+		if record.isTemperature() {
+			sensors = append(sensors, record)
+		}
+	}
+	return sensors, nil
+}
@@ -0,0 +1,223 @@
+package ipmi
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// `ipmitool sdr elist full` on a Supermicro X11 board,
+// entity 3.1 has several sensors.
+const supermicroSDRElist = `CPU Temp         | 01h | ok  |  3.1 | 45 degrees C
+CPU VRM Temp     | 08h | ok  |  3.1 | 52 degrees C
+CPU Prochot      | 02h | ok  |  3.1 | 0x00
+PCH Temp         | 0Ah | ok  |  7.1 | 48 degrees C
+System Temp      | 0Bh | ok  |  7.2 | 31 degrees C
+Peripheral Temp  | 0Ch | ok  |  7.3 | 40 degrees C
+VcpuVRM Temp     | 10h | ns  |  8.1 | No Reading
+DIMMA1 Temp      | B0h | ns  | 32.64 | Disabled
+Inlet Temp       | 0Dh | cr  | 64.1 | 113 degrees F
+12V              | 30h | ok  |  7.17 | 12.06 Volts`
+
+// `ipmitool sdr type Fan` on a Supermicro X11 board.
+const supermicroSDRFans = `FAN1             | 41h | ok  | 29.1 | 1400 RPM
+FAN2             | 42h | ns  | 29.2 | No Reading
+FAN3             | 43h | ok  | 29.3 | 1300 RPM
+FAN4             | 44h | ns  | 29.4 | No Reading
+FANA             | 45h | cr  | 29.5 | 100 RPM
+FANB             | 46h | ns  | 29.6 | No Reading`
+
+// `ipmitool sdr type Fan` on a Dell R720.
+const dellSDRFans = `Fan1 RPM         | 30h | ok  |  7.1 | 3240 RPM
+Fan2 RPM         | 31h | ok  |  7.1 | 3120 RPM
+Fan Redundancy   | 75h | ok  |  7.1 | Fully Redundant`
+
+func Test_parseFanReadings(t *testing.T) {
+	require.Equal(t, map[string]FanReading{
+		"FAN1": {Name: "FAN1", RPM: 1400, Status: "ok"},
+		"FAN2": {Name: "FAN2", Status: "ns"},
+		"FAN3": {Name: "FAN3", RPM: 1300, Status: "ok"},
+		"FAN4": {Name: "FAN4", Status: "ns"},
+		"FANA": {Name: "FANA", RPM: 100, Status: "cr"},
+		"FANB": {Name: "FANB", Status: "ns"},
+	}, parseFanReadings(parseSDRElist(supermicroSDRFans)))
+
+	require.Equal(t, map[string]FanReading{
+		"Fan1 RPM": {Name: "Fan1 RPM", RPM: 3240, Status: "ok"},
+		"Fan2 RPM": {Name: "Fan2 RPM", RPM: 3120, Status: "ok"},
+	}, parseFanReadings(parseSDRElist(dellSDRFans)))
+}
+
+func TestIPMI_GetFanRPMs(t *testing.T) {
+	bmc := newFakeBMC(t)
+	defer bmc.conn.Close()
+
+	ipmi := &IPMI{backend: newLAN(bmc.config(), time.Second)}
+	defer ipmi.Close()
+
+	rpms, err := ipmi.GetFanRPMs()
+	require.NoError(t, err)
+	require.Equal(t, map[string]uint16{"FAN1": 1200}, rpms)
+}
+
+// fansBackend serve supermicroSDRFans.
+type fansBackend struct {
+	backend
+}
+
+func (fansBackend) sdrFans() ([]SDRRecord, error) {
+	return parseSDRElist(supermicroSDRFans), nil
+}
+
+func TestIPMI_GetFanRPMs_noReading(t *testing.T) {
+	ipmi := &IPMI{backend: fansBackend{}}
+
+	rpms, err := ipmi.GetFanRPMs()
+	require.NoError(t, err)
+	require.Equal(t, map[string]uint16{"FAN1": 1400, "FAN2": 0, "FAN3": 1300, "FAN4": 0, "FANA": 100, "FANB": 0}, rpms)
+}
+
+func Test_parseSDRElist(t *testing.T) {
+	records := parseSDRElist(supermicroSDRElist + "\nmalformed row")
+	require.Len(t, records, 10)
+	require.Equal(t, SDRRecord{
+		Name: "CPU Temp", SensorID: "01h", Status: "ok", EntityID: "3.1",
+		Reading: "45 degrees C", Value: 45, Unit: "degrees C",
+	}, records[0])
+	require.Equal(t, SDRRecord{
+		Name: "CPU Prochot", SensorID: "02h", Status: "ok", EntityID: "3.1", Reading: "0x00",
+	}, records[2])
+	require.Equal(t, SDRRecord{
+		Name: "VcpuVRM Temp", SensorID: "10h", Status: "ns", EntityID: "8.1", Reading: "No Reading",
+	}, records[6])
+}
+
+func Test_sensorSelector(t *testing.T) {
+	records := parseSDRElist(supermicroSDRElist)
+
+	tests := []struct {
+		arg        string
+		want       float64
+		wantErr    bool
+		wantStatus bool
+		wantUnit   bool
+	}{
+		{arg: "3.1", want: 45},
+		{arg: "3.1:1", want: 52},
+		{arg: "3.1:2", wantUnit: true},
+		{arg: "3.1:3", wantErr: true},
+		{arg: "7.2", want: 31},
+		{arg: "Peripheral Temp", want: 40},
+		{arg: "12V", wantUnit: true},
+		{arg: "8.1", wantStatus: true},
+		{arg: "DIMMA1 Temp", wantStatus: true},
+		{arg: "64.1", want: 45},
+		{arg: "9.9", wantErr: true},
+		{arg: "Nope", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.arg, func(t *testing.T) {
+			var temp float64
+			record, err := parseSensorSelector(tt.arg).selectSensor(records)
+			if err == nil {
+				temp, err = record.temperature()
+			}
+
+			var statusErr *StatusError
+			var unitErr *UnitError
+			switch {
+			case tt.wantStatus:
+				require.True(t, errors.As(err, &statusErr), err)
+			case tt.wantUnit:
+				require.True(t, errors.As(err, &unitErr), err)
+			case tt.wantErr:
+				require.Error(t, err)
+				require.False(t, errors.As(err, &statusErr) || errors.As(err, &unitErr))
+			default:
+				require.NoError(t, err)
+				require.InDelta(t, tt.want, temp, 0.001)
+			}
+		})
+	}
+}
+
+// countingBackend serve supermicroSDRElist counting the sdrElist calls.
+type countingBackend struct {
+	backend
+	mutex sync.Mutex
+	calls int
+}
+
+func (b *countingBackend) sdrElist(entityID string) ([]SDRRecord, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.calls++
+	return parseSDRElist(supermicroSDRElist), nil
+}
+
+func TestIPMI_sdrCache(t *testing.T) {
+	b := &countingBackend{}
+	ipmi := &IPMI{backend: b, sdrCache: &sdrCache{ttl: time.Minute}}
+
+	args := []string{"3.1", "3.1:1", "7.2", "Peripheral Temp"}
+	errs := make([]error, len(args))
+	var wg sync.WaitGroup
+	for i, arg := range args {
+		wg.Add(1)
+		go func(i int, arg string) {
+			defer wg.Done()
+			_, errs[i] = ipmi.GetTemp(arg)
+		}(i, arg)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		require.NoError(t, err)
+	}
+	require.Equal(t, 1, b.calls)
+
+	// expired
+	ipmi.sdrCache.ttl = 0
+	temp, err := ipmi.GetTemp("7.3")
+	require.NoError(t, err)
+	require.Equal(t, float64(40), temp)
+	require.Equal(t, 2, b.calls)
+
+	// disabled
+	ipmi.sdrCache = nil
+	_, err = ipmi.GetTemp("7.3")
+	require.NoError(t, err)
+	require.Equal(t, 3, b.calls)
+}
+
+// entityBackend serve supermicroSDRElist as ipmitool does,
+// filtered by entity as `sdr entity` when entityID is set.
+type entityBackend struct {
+	backend
+}
+
+func (entityBackend) sdrElist(entityID string) ([]SDRRecord, error) {
+	records := make([]SDRRecord, 0)
+	for _, record := range parseSDRElist(supermicroSDRElist) {
+		if entityID == "" || record.EntityID == entityID {
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
+
+func TestIPMI_GetTemp_cacheConsistency(t *testing.T) {
+	cached := &IPMI{backend: entityBackend{}, sdrCache: &sdrCache{ttl: time.Minute}}
+	uncached := &IPMI{backend: entityBackend{}}
+
+	// 3.1:2 is the CPU Prochot discrete sensor
+	for _, arg := range []string{"3.1", "3.1:1", "3.1:2", "8.1", "Inlet Temp"} {
+		cachedTemp, cachedErr := cached.GetTemp(arg)
+		uncachedTemp, uncachedErr := uncached.GetTemp(arg)
+		require.Equal(t, uncachedTemp, cachedTemp, arg)
+		require.Equal(t, uncachedErr, cachedErr, arg)
+	}
+}
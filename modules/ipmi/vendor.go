@@ -0,0 +1,271 @@
+package ipmi
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+const (
+	VendorAuto          = "auto"
+	VendorSupermicroX9  = "supermicro_x9"
+	VendorSupermicroX10 = "supermicro_x10"
+	VendorSupermicroX11 = "supermicro_x11"
+	VendorDell          = "dell"
+	VendorASRockRack    = "asrock_rack"
+)
+
+// manufacturers are the vendor profiles
+// autodetected by IANA manufacturer ID.
+var manufacturers = map[uint32]string{
+	674:   VendorDell,
+	49622: VendorASRockRack,
+}
+
+// supermicroManufacturers are the Supermicro manufacturer IDs,
+// the X9 and X10/X11 boards can't be told apart from Get Device ID
+// and they need different commands, so the profile must be set explicitly.
+var supermicroManufacturers = map[uint32]bool{
+	10876: true,
+	47488: true,
+}
+
+const cmdGetDeviceID = 0x01
+
+// errSupermicro is returned by detectVendor for the Supermicro boards.
+var errSupermicro = errors.New("supermicro board detected, set `vendor` in ipmi.yaml to supermicro_x9, supermicro_x10 or supermicro_x11")
+
+// errNotSupported is returned by the profiles
+// for the requests the vendor doesn't provide.
+var errNotSupported = errors.New("not supported by the ipmi vendor profile")
+
+// rawRequest is an ipmi raw request.
+type rawRequest struct {
+	netFn, cmd byte
+	data       []byte
+}
+
+func newRaw(netFn, cmd byte, data ...byte) rawRequest {
+	return rawRequest{netFn: netFn, cmd: cmd, data: append([]byte{}, data...)}
+}
+
+// String return the request as ipmitool arguments.
+func (r rawRequest) String() string {
+	s := fmt.Sprintf("raw 0x%02x 0x%02x", r.netFn, r.cmd)
+	for _, b := range r.data {
+		s += fmt.Sprintf(" 0x%02x", b)
+	}
+	return s
+}
+
+// vendorProfile build the vendor specific (OEM) fan requests.
+// Zones are the fan channels used in the tmi targets_map.
+type vendorProfile interface {
+	// zones return the zones of the profile.
+	zones() []uint8
+
+	getDuty(zone uint8) (rawRequest, error)
+	// duty parse the getDuty response into a duty-cycle in %.
+	duty(zone uint8, resp []byte) (uint8, error)
+	setDuty(zone, dc uint8) (rawRequest, error)
+
+	getMode() (rawRequest, error)
+	// mode parse the getMode response.
+	mode(resp []byte) (fanMode, error)
+	setMode(mode fanMode) (rawRequest, error)
+}
+
+// newProfile return the vendor profile with the given name.
+func newProfile(vendor string) (vendorProfile, error) {
+	switch vendor {
+	case VendorSupermicroX9:
+		return supermicroX9{}, nil
+	case VendorSupermicroX10, VendorSupermicroX11:
+		return supermicroX11{}, nil
+	case VendorDell:
+		return dell{}, nil
+	case VendorASRockRack:
+		return &asrockRack{}, nil
+	default:
+		return nil, fmt.Errorf("unknown ipmi vendor `%s`, use auto, supermicro_x9, supermicro_x10, supermicro_x11, dell or asrock_rack", vendor)
+	}
+}
+
+// detectVendor return the vendor profile name
+// from the manufacturer ID of `mc info` (Get Device ID).
+func detectVendor(b backend) (string, error) {
+	resp, err := b.raw(netFnApp, cmdGetDeviceID)
+	if err != nil {
+		return "", err
+	}
+	if len(resp) < 9 {
+		return "", errors.New("invalid device id response")
+	}
+
+	id := uint32(resp[6]) | uint32(resp[7])<<8 | uint32(resp[8]&0x0f)<<16
+	if supermicroManufacturers[id] {
+		return "", errSupermicro
+	}
+	vendor, ok := manufacturers[id]
+	if !ok {
+		return "", fmt.Errorf("no ipmi vendor profile for manufacturer ID %d, set `vendor` in ipmi.yaml", id)
+	}
+	return vendor, nil
+}
+
+// seeder is implemented by the profiles setting all the zones at once,
+// they are seeded with the getDuty response before the first setDuty
+// so that the zones not controlled by tmi keep their duty-cycles.
+type seeder interface {
+	seed(resp []byte)
+}
+
+func firstByte(resp []byte) (byte, error) {
+	if len(resp) == 0 {
+		return 0, errors.New("empty response")
+	}
+	return resp[0], nil
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// supermicroX11 is the X10 and X11 boards profile,
+// zone 0 is the CPU zone (FAN1-...), zone 1 the peripheral zone (FANA-...).
+type supermicroX11 struct{}
+
+func (supermicroX11) zones() []uint8 {
+	return []uint8{0, 1}
+}
+
+func (supermicroX11) getDuty(zone uint8) (rawRequest, error) {
+	return newRaw(0x30, 0x70, 0x66, 0x00, zone), nil
+}
+
+func (supermicroX11) duty(zone uint8, resp []byte) (uint8, error) {
+	return firstByte(resp)
+}
+
+func (supermicroX11) setDuty(zone, dc uint8) (rawRequest, error) {
+	return newRaw(0x30, 0x70, 0x66, 0x01, zone, dc), nil
+}
+
+func (supermicroX11) getMode() (rawRequest, error) {
+	return newRaw(0x30, 0x45, 0x00), nil
+}
+
+func (supermicroX11) mode(resp []byte) (fanMode, error) {
+	m, err := firstByte(resp)
+	return fanMode(fmt.Sprintf("%02x", m)), err
+}
+
+func (supermicroX11) setMode(mode fanMode) (rawRequest, error) {
+	m, err := strconv.ParseUint(string(mode), 16, 8)
+	if err != nil {
+		return rawRequest{}, err
+	}
+	return newRaw(0x30, 0x45, 0x01, byte(m)), nil
+}
+
+// supermicroX9 is the X9 boards profile, same zones and modes of the X11,
+// the duty-cycle is set in the 0-255 range and can't be read back.
+type supermicroX9 struct {
+	supermicroX11
+}
+
+func (supermicroX9) getDuty(zone uint8) (rawRequest, error) {
+	return rawRequest{}, errNotSupported
+}
+
+func (supermicroX9) setDuty(zone, dc uint8) (rawRequest, error) {
+	return newRaw(0x30, 0x91, 0x5a, 0x03, 0x10+zone, byte(uint(dc)*255/100)), nil
+}
+
+// dell is the iDRAC (R7xx) profile, zones are the fan indexes, 255 is all the fans.
+// Any mode but full gives the control back to the iDRAC.
+type dell struct{}
+
+func (dell) zones() []uint8 {
+	return []uint8{255}
+}
+
+func (dell) getDuty(zone uint8) (rawRequest, error) {
+	return rawRequest{}, errNotSupported
+}
+
+func (dell) duty(zone uint8, resp []byte) (uint8, error) {
+	return 0, errNotSupported
+}
+
+func (dell) setDuty(zone, dc uint8) (rawRequest, error) {
+	return newRaw(0x30, 0x30, 0x02, zone, dc), nil
+}
+
+func (dell) getMode() (rawRequest, error) {
+	return rawRequest{}, errNotSupported
+}
+
+func (dell) mode(resp []byte) (fanMode, error) {
+	return "", errNotSupported
+}
+
+func (dell) setMode(mode fanMode) (rawRequest, error) {
+	if mode == FanModeFull {
+		// manual control
+		return newRaw(0x30, 0x30, 0x01, 0x00), nil
+	}
+	return newRaw(0x30, 0x30, 0x01, 0x01), nil
+}
+
+// asrockRack is the ASRock Rack profile, zones are the fan headers (0-7).
+// All the duty-cycles are set at once, 0 leaves a fan in automatic mode,
+// so the fans not controlled by tmi stay in automatic mode.
+// Any mode but full gives the control of all the fans back to the BMC.
+type asrockRack struct {
+	duties [8]byte
+}
+
+func (a *asrockRack) zones() []uint8 {
+	zones := make([]uint8, len(a.duties))
+	for i := range zones {
+		zones[i] = uint8(i)
+	}
+	return zones
+}
+
+func (a *asrockRack) getDuty(zone uint8) (rawRequest, error) {
+	return newRaw(0x3a, 0x02), nil
+}
+
+func (a *asrockRack) duty(zone uint8, resp []byte) (uint8, error) {
+	if int(zone) >= len(resp) {
+		return 0, fmt.Errorf("no such fan header: %d", zone)
+	}
+	return resp[zone], nil
+}
+
+func (a *asrockRack) seed(resp []byte) {
+	copy(a.duties[:], resp)
+}
+
+func (a *asrockRack) setDuty(zone, dc uint8) (rawRequest, error) {
+	if int(zone) >= len(a.duties) {
+		return rawRequest{}, fmt.Errorf("no such fan header: %d", zone)
+	}
+	a.duties[zone] = dc
+	return newRaw(0x3a, 0x01, a.duties[:]...), nil
+}
+
+func (a *asrockRack) getMode() (rawRequest, error) {
+	return rawRequest{}, errNotSupported
+}
+
+func (a *asrockRack) mode(resp []byte) (fanMode, error) {
+	return "", errNotSupported
+}
+
+func (a *asrockRack) setMode(mode fanMode) (rawRequest, error) {
+	if mode != FanModeFull {
+		a.duties = [8]byte{}
+	}
+	return newRaw(0x3a, 0x01, a.duties[:]...), nil
+}
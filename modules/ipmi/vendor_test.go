@@ -0,0 +1,204 @@
+package ipmi
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// golden renders the requests of a profile as ipmitool arguments,
+// unsupported requests are rendered as "-".
+func golden(req rawRequest, err error) string {
+	if err == errNotSupported {
+		return "-"
+	}
+	if err != nil {
+		return "error: " + err.Error()
+	}
+	return req.String()
+}
+
+func TestVendorProfiles(t *testing.T) {
+	tests := []struct {
+		vendor string
+		want   []string
+	}{
+		{
+			vendor: VendorSupermicroX9,
+			want: []string{
+				"-",
+				"raw 0x30 0x91 0x5a 0x03 0x10 0x7f",
+				"raw 0x30 0x91 0x5a 0x03 0x11 0xff",
+				"raw 0x30 0x45 0x00",
+				"raw 0x30 0x45 0x01 0x01",
+				"raw 0x30 0x45 0x01 0x00",
+			},
+		},
+		{
+			vendor: VendorSupermicroX10,
+			want: []string{
+				"raw 0x30 0x70 0x66 0x00 0x00",
+				"raw 0x30 0x70 0x66 0x01 0x00 0x32",
+				"raw 0x30 0x70 0x66 0x01 0x01 0x64",
+				"raw 0x30 0x45 0x00",
+				"raw 0x30 0x45 0x01 0x01",
+				"raw 0x30 0x45 0x01 0x00",
+			},
+		},
+		{
+			vendor: VendorSupermicroX11,
+			want: []string{
+				"raw 0x30 0x70 0x66 0x00 0x00",
+				"raw 0x30 0x70 0x66 0x01 0x00 0x32",
+				"raw 0x30 0x70 0x66 0x01 0x01 0x64",
+				"raw 0x30 0x45 0x00",
+				"raw 0x30 0x45 0x01 0x01",
+				"raw 0x30 0x45 0x01 0x00",
+			},
+		},
+		{
+			vendor: VendorDell,
+			want: []string{
+				"-",
+				"raw 0x30 0x30 0x02 0x00 0x32",
+				"raw 0x30 0x30 0x02 0x01 0x64",
+				"-",
+				"raw 0x30 0x30 0x01 0x00",
+				"raw 0x30 0x30 0x01 0x01",
+			},
+		},
+		{
+			vendor: VendorASRockRack,
+			want: []string{
+				"raw 0x3a 0x02",
+				"raw 0x3a 0x01 0x32 0x00 0x00 0x00 0x00 0x00 0x00 0x00",
+				"raw 0x3a 0x01 0x32 0x64 0x00 0x00 0x00 0x00 0x00 0x00",
+				"-",
+				"raw 0x3a 0x01 0x32 0x64 0x00 0x00 0x00 0x00 0x00 0x00",
+				"raw 0x3a 0x01 0x00 0x00 0x00 0x00 0x00 0x00 0x00 0x00",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.vendor, func(t *testing.T) {
+			p, err := newProfile(tt.vendor)
+			require.NoError(t, err)
+
+			got := []string{
+				golden(p.getDuty(0)),
+				golden(p.setDuty(0, 50)),
+				golden(p.setDuty(1, 100)),
+				golden(p.getMode()),
+				golden(p.setMode(FanModeFull)),
+				golden(p.setMode(FanModeStandard)),
+			}
+			require.Equal(t, tt.want, got)
+		})
+	}
+
+	_, err := newProfile("hp")
+	require.Error(t, err)
+}
+
+func TestVendorProfiles_responses(t *testing.T) {
+	mode, err := supermicroX11{}.mode([]byte{0x02})
+	require.NoError(t, err)
+	require.Equal(t, FanModeOptimal, mode)
+
+	dc, err := (&asrockRack{}).duty(2, []byte{10, 20, 30, 40, 50, 60, 70, 80})
+	require.NoError(t, err)
+	require.Equal(t, uint8(30), dc)
+
+	_, err = (&asrockRack{}).setDuty(8, 50)
+	require.Error(t, err)
+}
+
+func Test_detectVendor(t *testing.T) {
+	bmc := newFakeBMC(t)
+	defer bmc.conn.Close()
+
+	for id, vendor := range map[uint32]string{674: VendorDell, 49622: VendorASRockRack} {
+		bmc.mutex.Lock()
+		bmc.manufacturer = id
+		bmc.mutex.Unlock()
+
+		l := newLAN(bmc.config(), time.Second)
+		got, err := detectVendor(l)
+		l.close()
+		require.NoError(t, err)
+		require.Equal(t, vendor, got)
+	}
+
+	// unknown and supermicro, that must be set explicitly
+	for _, id := range []uint32{11, 10876} {
+		bmc.mutex.Lock()
+		bmc.manufacturer = id
+		bmc.mutex.Unlock()
+
+		l := newLAN(bmc.config(), time.Second)
+		_, err := detectVendor(l)
+		l.close()
+		require.Error(t, err)
+	}
+}
+
+func TestVendorProfiles_zones(t *testing.T) {
+	require.Equal(t, []uint8{0, 1}, supermicroX9{}.zones())
+	require.Equal(t, []uint8{255}, dell{}.zones())
+	require.Equal(t, []uint8{0, 1, 2, 3, 4, 5, 6, 7}, (&asrockRack{}).zones())
+}
+
+// asrockBackend serve the ASRock Rack duty-cycles.
+type asrockBackend struct {
+	backend
+	duties []byte
+}
+
+func (b *asrockBackend) raw(netFn, cmd byte, data ...byte) ([]byte, error) {
+	switch cmd {
+	case 0x01:
+		b.duties = append([]byte{}, data...)
+	case 0x02:
+		return b.duties, nil
+	}
+	return nil, nil
+}
+
+func TestIPMI_asrockRack_seed(t *testing.T) {
+	b := &asrockBackend{duties: []byte{10, 20, 30, 40, 50, 60, 70, 80}}
+	ipmi := &IPMI{Config: Config{Vendor: VendorASRockRack}, backend: b, zonesDutyCycles: make(map[uint8]uint8)}
+
+	require.NoError(t, ipmi.SetChannelDutyCycle(2, 100))
+	require.Equal(t, []byte{10, 20, 100, 40, 50, 60, 70, 80}, b.duties)
+
+	// a reloaded config seeds the profile again
+	ipmi.profile = nil
+	b.duties[0] = 15
+	require.NoError(t, ipmi.SetChannelDutyCycle(1, 25))
+	require.Equal(t, []byte{15, 25, 100, 40, 50, 60, 70, 80}, b.duties)
+}
+
+// deviceIDBackend answer Get Device ID with the given manufacturer.
+type deviceIDBackend struct {
+	backend
+	manufacturer uint32
+}
+
+func (b deviceIDBackend) raw(netFn, cmd byte, data ...byte) ([]byte, error) {
+	m := b.manufacturer
+	return []byte{0x20, 0x01, 0x01, 0x02, 0x02, 0xbf, byte(m), byte(m >> 8), byte(m >> 16), 0x00, 0x00}, nil
+}
+
+func TestIPMI_vendorProfile_supermicro(t *testing.T) {
+	// no vendor in ipmi.yaml (older configs)
+	ipmi := &IPMI{backend: deviceIDBackend{manufacturer: 10876}}
+	p, err := ipmi.vendorProfile()
+	require.NoError(t, err)
+	require.Equal(t, supermicroX11{}, p)
+
+	ipmi = &IPMI{Config: Config{Vendor: VendorAuto}, backend: deviceIDBackend{manufacturer: 10876}}
+	_, err = ipmi.vendorProfile()
+	require.Error(t, err)
+}
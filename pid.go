@@ -0,0 +1,75 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// pid is a closed-loop controller driving
+// the duty-cycle toward a temperature setpoint.
+type pid struct {
+	// Setpoint is the temperature to hold, in °C.
+	Setpoint float64 `yaml:"setpoint"`
+
+	// Kp, Ki and Kd are the proportional, integral and derivative gains.
+	// The error is the measured temp minus the setpoint (in °C),
+	// the integral and the derivative are calculated over seconds.
+	Kp float64 `yaml:"kp"`
+	Ki float64 `yaml:"ki"`
+	Kd float64 `yaml:"kd"`
+
+	// MinDuty and MaxDuty clamp the resulting duty-cycle,
+	// MaxDuty defaults to 100 if not set.
+	MinDuty uint8 `yaml:"min_duty"`
+	MaxDuty uint8 `yaml:"max_duty"`
+
+	// runtime vars -----------------------------------
+
+	now      func() time.Time
+	lastTime time.Time
+	lastTemp float64
+	integral float64
+}
+
+func (p *pid) limits() (min, max float64) {
+	max = 100
+	if p.MaxDuty > 0 {
+		max = float64(p.MaxDuty)
+	}
+	return float64(p.MinDuty), max
+}
+
+// update return the duty-cycle needed for the given temp.
+func (p *pid) update(temp float64) uint8 {
+	if p.now == nil {
+		p.now = time.Now
+	}
+	now := p.now()
+
+	var dt, derivative float64
+	if !p.lastTime.IsZero() {
+		dt = now.Sub(p.lastTime).Seconds()
+	}
+	if dt > 0 {
+		// derivative on measurement, no kick on setpoint changes
+		derivative = (temp - p.lastTemp) / dt
+	}
+	p.lastTime = now
+	p.lastTemp = temp
+
+	minDuty, maxDuty := p.limits()
+	e := temp - p.Setpoint
+
+	integral := p.integral + e*dt
+	out := p.Kp*e + p.Ki*integral + p.Kd*derivative
+
+	// anti-windup: stop integrating while the output is saturated
+	// and the error would push it further out of range.
+	if (out > maxDuty && e > 0) || (out < minDuty && e < 0) {
+		out = p.Kp*e + p.Ki*p.integral + p.Kd*derivative
+	} else {
+		p.integral = integral
+	}
+
+	return uint8(math.Round(math.Max(minDuty, math.Min(maxDuty, out))))
+}
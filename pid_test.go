@@ -0,0 +1,75 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// thermalPlant is a simulated water loop: a constant heat load
+// and a dissipation proportional to fan speed and to the
+// difference from the ambient temperature.
+type thermalPlant struct {
+	temp    float64
+	ambient float64
+	load    float64 // °C/s added by the heat source
+	cooling float64 // dissipation coefficient at 100% duty-cycle
+}
+
+func (p *thermalPlant) step(dutyCycle uint8, dt float64) {
+	airflow := 0.1 + 0.9*float64(dutyCycle)/100
+	p.temp += (p.load - p.cooling*airflow*(p.temp-p.ambient)) * dt
+}
+
+func Test_pid_simulatedPlant(t *testing.T) {
+	clock := time.Unix(0, 0)
+	const tick = 2 * time.Second
+
+	c := &controller{
+		Name: "water",
+		Temp: tempSource{Method: "commanderpro", Arg: "0"},
+		Mode: ModePID,
+		PID: pid{
+			Setpoint: 35,
+			Kp:       8,
+			Ki:       0.2,
+			Kd:       4,
+			MinDuty:  20,
+			now:      func() time.Time { return clock },
+		},
+		Targets: map[string]map[float64]uint8{"pump": nil, "rad": nil},
+	}
+	require.NoError(t, c.validate())
+
+	plant := &thermalPlant{temp: 25, ambient: 25, load: 0.3, cooling: 0.05}
+
+	var dc uint8
+	for i := 0; i < 1000; i++ {
+		td := c.getNeededDutyCycles(plant.temp)
+		require.Equal(t, td["pump"].dutyCycle, td["rad"].dutyCycle)
+		dc = td["pump"].dutyCycle
+		require.True(t, dc >= 20 && dc <= 100, "duty-cycle out of range: %d", dc)
+
+		plant.step(dc, tick.Seconds())
+		clock = clock.Add(tick)
+	}
+
+	require.True(t, math.Abs(plant.temp-35) < 0.5, "temp not settled: %.2f", plant.temp)
+	require.True(t, dc > 20 && dc < 100, "duty-cycle saturated: %d", dc)
+}
+
+func Test_pid_antiWindup(t *testing.T) {
+	clock := time.Unix(0, 0)
+	p := &pid{Setpoint: 30, Kp: 2, Ki: 1, MaxDuty: 80, now: func() time.Time { return clock }}
+
+	// a long overheat can not wind up the integral
+	for i := 0; i < 100; i++ {
+		require.Equal(t, uint8(80), p.update(90))
+		clock = clock.Add(time.Second)
+	}
+
+	// so the output leaves saturation as soon as the temp drops
+	require.Equal(t, uint8(0), p.update(20))
+}
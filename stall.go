@@ -0,0 +1,88 @@
+package main
+
+import "errors"
+
+// stallDetection raise a "fan stalled" condition when the rpm
+// of a target stays below MinRPM for Ticks consecutive checks
+// while its duty-cycle is not zero.
+// Only targets able to read back their rpm are checked: the ones
+// whose fanController implements rpmReader or with fans read through a fanReader.
+type stallDetection struct {
+	Enabled bool `yaml:"enabled"`
+
+	// MinRPM is the minimum rpm of a running fan.
+	MinRPM uint16 `yaml:"min_rpm"`
+
+	// Ticks is the number of consecutive checks
+	// below MinRPM needed to raise the stalled condition.
+	Ticks int `yaml:"ticks"`
+
+	// Command is an optional shell command executed through the cli module
+	// when a fan stalls, `{target}` is replaced with the target name.
+	Command string `yaml:"command"`
+
+	// Compensate force the other targets to 100% while a fan is stalled.
+	Compensate bool `yaml:"compensate"`
+
+	// runtime vars -----------------------------------
+
+	// <target> : consecutive checks below MinRPM
+	counters map[string]int
+	stalled  map[string]bool
+}
+
+// validate check the stall detection params, if enabled.
+func (s *stallDetection) validate() error {
+	if !s.Enabled {
+		return nil
+	}
+	if s.Ticks < 1 {
+		return errors.New("stall_detection ticks must be at least 1")
+	}
+	return nil
+}
+
+// update record the rpm read for the given target,
+// returns true if the target stalled state has changed.
+func (s *stallDetection) update(target string, rpm uint16) (changed bool) {
+	if s.counters == nil {
+		s.counters = make(map[string]int)
+		s.stalled = make(map[string]bool)
+	}
+
+	if rpm == 0 || rpm < s.MinRPM {
+		s.counters[target]++
+		if s.counters[target] >= s.Ticks && !s.stalled[target] {
+			s.stalled[target] = true
+			return true
+		}
+		return false
+	}
+
+	s.counters[target] = 0
+	if s.stalled[target] {
+		s.stalled[target] = false
+		return true
+	}
+	return false
+}
+
+// reset the target counter and stalled condition, to be used while
+// its duty-cycle is zero and the fan is not expected to spin,
+// returns true if the target was stalled.
+func (s *stallDetection) reset(target string) (wasStalled bool) {
+	wasStalled = s.stalled[target]
+	delete(s.counters, target)
+	delete(s.stalled, target)
+	return
+}
+
+// anyStalled return true if some target is stalled.
+func (s *stallDetection) anyStalled() bool {
+	for _, stalled := range s.stalled {
+		if stalled {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_stallDetection_update(t *testing.T) {
+	s := &stallDetection{Enabled: true, MinRPM: 200, Ticks: 3}
+
+	tests := []struct {
+		name        string
+		rpm         uint16
+		wantChanged bool
+		wantStalled bool
+	}{
+		{name: "running", rpm: 900},
+		{name: "stopped 1", rpm: 0},
+		{name: "slow 2", rpm: 150},
+		{name: "stalled", rpm: 0, wantChanged: true, wantStalled: true},
+		{name: "still stalled", rpm: 0, wantStalled: true},
+		{name: "recovered", rpm: 800, wantChanged: true},
+		{name: "slow again", rpm: 100},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.wantChanged, s.update("pump", tt.rpm))
+			require.Equal(t, tt.wantStalled, s.stalled["pump"])
+			require.Equal(t, tt.wantStalled, s.anyStalled())
+		})
+	}
+
+	s.reset("pump")
+	require.Equal(t, 0, s.counters["pump"])
+}
+
+func TestControlManager_checkStalls(t *testing.T) {
+	cm := &ControlManager{
+		Config: Config{StallDetection: stallDetection{
+			Enabled: true, MinRPM: 200, Ticks: 2, Compensate: true,
+		}},
+		targetsDutyCycle: map[string]uint8{"pump": 50, "side": 50},
+	}
+
+	rpms := map[string]uint16{"pump": 0, "side": 900}
+	cm.checkStalls(rpms)
+	cm.checkStalls(rpms)
+	require.True(t, cm.StallDetection.stalled["pump"])
+	require.True(t, cm.StallDetection.anyStalled())
+
+	// duty 0, the fan is not expected to spin anymore
+	cm.targetsDutyCycle["pump"] = 0
+	cm.checkStalls(rpms)
+	require.False(t, cm.StallDetection.anyStalled())
+
+	// stalled again, then recovered
+	cm.targetsDutyCycle["pump"] = 50
+	cm.checkStalls(rpms)
+	cm.checkStalls(rpms)
+	require.True(t, cm.StallDetection.anyStalled())
+	cm.checkStalls(map[string]uint16{"pump": 800, "side": 900})
+	require.False(t, cm.StallDetection.anyStalled())
+}
+
+func Test_stallDetection_validate(t *testing.T) {
+	require.NoError(t, (&stallDetection{}).validate(), "disabled")
+	require.NoError(t, (&stallDetection{Enabled: true, Ticks: 1}).validate())
+	require.Error(t, (&stallDetection{Enabled: true}).validate())
+	require.Error(t, (&stallDetection{Enabled: true, Ticks: -1}).validate())
+}
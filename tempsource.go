@@ -0,0 +1,262 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+)
+
+// aggregate is the method used to combine
+// the readings of a composite temp source.
+type aggregate string
+
+const (
+	// AggregateMax use the hottest source.
+	AggregateMax aggregate = "max"
+	// AggregateMin use the coldest source.
+	AggregateMin aggregate = "min"
+	// AggregateAvg use the mean of the sources.
+	AggregateAvg aggregate = "avg"
+	// AggregateWeighted use the mean of the sources weighted by their Weight.
+	AggregateWeighted aggregate = "weighted"
+)
+
+// methodDelta is the tempSource method for the difference between two sources.
+const methodDelta = "delta"
+
+// methodSource is the tempExtractor name of the named temp sources.
+const methodSource = "source"
+
+// tempSource describe how to obtain a temperature.
+// It could be a single reading from a tempExtractor module (Method and Arg),
+// a composite of several sources (Aggregate and Sources)
+// or the difference between two sources (delta Method, Minuend and Subtrahend).
+type tempSource struct {
+	// commanderpro, ipmi, cli, delta
+	// commanderpro: sensor_channel (uint8 as string), ipmi: entityID, cli: custom_command
+	Method string `yaml:"method"`
+	Arg    string `yaml:"arg"`
+
+	// Minuend and Subtrahend are the sources of a delta temp source,
+	// the resulting temp is minuend - subtrahend (eg.: water - ambient).
+	Minuend    *tempSource `yaml:"minuend"`
+	Subtrahend *tempSource `yaml:"subtrahend"`
+
+	// Aggregate is the method used to combine Sources: max, min, avg or weighted.
+	Aggregate aggregate `yaml:"aggregate"`
+	// Sources are the sources of a composite temp source.
+	Sources []*tempSource `yaml:"sources"`
+
+	// Weight is the source weight in a weighted aggregate,
+	// the result is divided by the sum of all the weights.
+	Weight float64 `yaml:"weight"`
+	// Offset is added to the reading (in °C).
+	Offset float64 `yaml:"offset"`
+}
+
+func (ts *tempSource) validate() error {
+	if ts.Method == methodDelta {
+		if ts.Minuend == nil || ts.Subtrahend == nil {
+			return errors.New("delta temp source needs a minuend and a subtrahend")
+		}
+		if err := ts.Minuend.validate(); err != nil {
+			return err
+		}
+		return ts.Subtrahend.validate()
+	}
+
+	if len(ts.Sources) == 0 {
+		if ts.Method == "" {
+			return errors.New("temp source needs a method or a list of sources")
+		}
+		return nil
+	}
+
+	switch ts.Aggregate {
+	case AggregateMax, AggregateMin, AggregateAvg:
+	case AggregateWeighted:
+		var weights float64
+		for _, s := range ts.Sources {
+			if s.Weight < 0 {
+				return fmt.Errorf("weighted aggregate: negative weight %v", s.Weight)
+			}
+			weights += s.Weight
+		}
+		if weights <= 0 {
+			return errors.New("weighted aggregate needs a positive sum of weights")
+		}
+	default:
+		return fmt.Errorf("unknown aggregate `%s`, use max, min, avg or weighted", ts.Aggregate)
+	}
+
+	for _, s := range ts.Sources {
+		if err := s.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// args return the args of the sources using method.
+func (ts *tempSource) args(method string) (args []string) {
+	if ts == nil {
+		return nil
+	}
+	if ts.Method == method {
+		args = append(args, ts.Arg)
+	}
+	args = append(args, ts.Minuend.args(method)...)
+	args = append(args, ts.Subtrahend.args(method)...)
+	for _, s := range ts.Sources {
+		args = append(args, s.args(method)...)
+	}
+	return args
+}
+
+// getTemp return the temperature resolving
+// the source methods through tempGetters.
+func (ts *tempSource) getTemp(tempGetters map[string]tempExtractor) (temp float64, err error) {
+	if ts.Method == methodDelta {
+		var minuend, subtrahend float64
+		if minuend, err = ts.Minuend.getTemp(tempGetters); err != nil {
+			return
+		}
+		if subtrahend, err = ts.Subtrahend.getTemp(tempGetters); err != nil {
+			return
+		}
+		return minuend - subtrahend + ts.Offset, nil
+	}
+
+	if len(ts.Sources) == 0 {
+		tg, ok := tempGetters[ts.Method]
+		if !ok {
+			return 0, fmt.Errorf("no such temp method: %s", ts.Method)
+		}
+		if temp, err = tg.GetTemp(ts.Arg); err != nil {
+			return
+		}
+		return temp + ts.Offset, nil
+	}
+
+	var sum, weights float64
+	switch ts.Aggregate {
+	case AggregateMax:
+		temp = math.Inf(-1)
+	case AggregateMin:
+		temp = math.Inf(1)
+	}
+
+	for _, s := range ts.Sources {
+		var t float64
+		if t, err = s.getTemp(tempGetters); err != nil {
+			return 0, err
+		}
+
+		switch ts.Aggregate {
+		case AggregateMax:
+			temp = math.Max(temp, t)
+		case AggregateMin:
+			temp = math.Min(temp, t)
+		case AggregateAvg:
+			sum += t
+			weights++
+		case AggregateWeighted:
+			sum += s.Weight * t
+			weights += s.Weight
+		}
+	}
+
+	if ts.Aggregate == AggregateAvg || ts.Aggregate == AggregateWeighted {
+		temp = sum / weights
+	}
+	return temp + ts.Offset, nil
+}
+
+// sources is the tempExtractor for the named temp sources of tmi.yaml,
+// making composite and delta sources available to the other modules
+// (eg.: commanderpro external_temps with `method: source` and `arg: <name>`).
+// The other modules call it from their own goroutines, so it reads
+// a snapshot of the config replaced on reload, not the ControlManager.
+type sources struct {
+	mutex       sync.RWMutex
+	tempSources map[string]*tempSource
+	tempGetters map[string]tempExtractor
+}
+
+// update replace the snapshot with the reloaded temp sources and modules.
+func (s *sources) update(tempSources map[string]*tempSource, tempGetters map[string]tempExtractor) {
+	getters := make(map[string]tempExtractor, len(tempGetters))
+	for name, tg := range tempGetters {
+		getters[name] = tg
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.tempSources = tempSources
+	s.tempGetters = getters
+}
+
+// extract return the temp of a tempExtractor module by name.
+func (s *sources) extract(method, arg string) (temp float64, err error) {
+	s.mutex.RLock()
+	tg, ok := s.tempGetters[method]
+	s.mutex.RUnlock()
+
+	if !ok {
+		return 0, fmt.Errorf("no such temp method: %s", method)
+	}
+	return tg.GetTemp(arg)
+}
+
+// module interface implementation
+func (s *sources) Name() string {
+	return methodSource
+}
+
+// tempExtractor interface implementation
+func (s *sources) GetTemp(name string) (temp float64, err error) {
+	s.mutex.RLock()
+	ts, ok := s.tempSources[name]
+	tempGetters := s.tempGetters
+	s.mutex.RUnlock()
+
+	if !ok {
+		return 0, fmt.Errorf("no such temp source: %s", name)
+	}
+	return ts.getTemp(tempGetters)
+}
+
+// checkSourceCycles return an error if a named temp source refers to itself,
+// directly or through other named sources.
+func checkSourceCycles(named map[string]*tempSource) error {
+	var visit func(ts *tempSource, path map[string]bool) error
+	visit = func(ts *tempSource, path map[string]bool) error {
+		if ts == nil {
+			return nil
+		}
+		if ts.Method == methodSource {
+			if path[ts.Arg] {
+				return fmt.Errorf("temp source %s refers to itself", ts.Arg)
+			}
+			path[ts.Arg] = true
+			defer delete(path, ts.Arg)
+			return visit(named[ts.Arg], path)
+		}
+
+		children := append([]*tempSource{ts.Minuend, ts.Subtrahend}, ts.Sources...)
+		for _, child := range children {
+			if err := visit(child, path); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for name, ts := range named {
+		if err := visit(ts, map[string]bool{name: true}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeTempExtractor return the temps by arg.
+type fakeTempExtractor map[string]float64
+
+func (f fakeTempExtractor) Name() string {
+	return "fake"
+}
+
+func (f fakeTempExtractor) GetTemp(arg string) (float64, error) {
+	temp, ok := f[arg]
+	if !ok {
+		return 0, errors.New("no such sensor: " + arg)
+	}
+	return temp, nil
+}
+
+func Test_tempSource_getTemp(t *testing.T) {
+	tempGetters := map[string]tempExtractor{
+		"fake": fakeTempExtractor{"cpu": 60, "water": 30, "gpu": 70, "ambient": 22},
+	}
+
+	sources := func() []*tempSource {
+		return []*tempSource{
+			{Method: "fake", Arg: "cpu", Weight: 0.7},
+			{Method: "fake", Arg: "water", Weight: 0.3, Offset: 2},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		source  *tempSource
+		want    float64
+		wantErr bool
+	}{
+		{name: "single", source: &tempSource{Method: "fake", Arg: "gpu"}, want: 70},
+		{name: "single with offset", source: &tempSource{Method: "fake", Arg: "gpu", Offset: -5}, want: 65},
+		{name: "max", source: &tempSource{Aggregate: AggregateMax, Sources: sources()}, want: 60},
+		{name: "min", source: &tempSource{Aggregate: AggregateMin, Sources: sources()}, want: 32},
+		{name: "avg", source: &tempSource{Aggregate: AggregateAvg, Sources: sources()}, want: 46},
+		{name: "weighted", source: &tempSource{Aggregate: AggregateWeighted, Sources: sources()}, want: 51.6},
+		{name: "delta", source: &tempSource{Method: methodDelta,
+			Minuend:    &tempSource{Method: "fake", Arg: "water"},
+			Subtrahend: &tempSource{Method: "fake", Arg: "ambient"},
+		}, want: 8},
+		{name: "delta of composite", source: &tempSource{Method: methodDelta,
+			Minuend:    &tempSource{Aggregate: AggregateMax, Sources: sources()},
+			Subtrahend: &tempSource{Method: "fake", Arg: "ambient"},
+		}, want: 38},
+		{name: "no such method", source: &tempSource{Method: "nope"}, wantErr: true},
+		{name: "failing source", source: &tempSource{Aggregate: AggregateMax, Sources: []*tempSource{
+			{Method: "fake", Arg: "cpu"},
+			{Method: "fake", Arg: "nope"},
+		}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.NoError(t, tt.source.validate())
+			got, err := tt.source.getTemp(tempGetters)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.InDelta(t, tt.want, got, 0.0001)
+		})
+	}
+}
+
+func Test_tempSource_validate_weights(t *testing.T) {
+	weighted := func(weights ...float64) *tempSource {
+		ts := &tempSource{Aggregate: AggregateWeighted}
+		for _, w := range weights {
+			ts.Sources = append(ts.Sources, &tempSource{Method: "fake", Arg: "cpu", Weight: w})
+		}
+		return ts
+	}
+
+	require.NoError(t, weighted(0.7, 0.3).validate())
+	require.NoError(t, weighted(1, 0).validate())
+	require.Error(t, weighted(0, 0).validate())
+	require.Error(t, weighted(2, -1).validate())
+}
+
+func Test_sources_GetTemp(t *testing.T) {
+	cm := &ControlManager{
+		tempGetters: map[string]tempExtractor{
+			"fake": fakeTempExtractor{"cpu": 60, "water": 30},
+		},
+		Config: Config{TempSources: map[string]*tempSource{
+			"hottest": {Aggregate: AggregateMax, Sources: []*tempSource{
+				{Method: "fake", Arg: "cpu"},
+				{Method: "fake", Arg: "water"},
+			}},
+			"hottest+5": {Method: methodSource, Arg: "hottest", Offset: 5},
+		}},
+	}
+	cm.sources = &sources{}
+	cm.addModule(cm.sources)
+	cm.sources.update(cm.TempSources, cm.tempGetters)
+	require.NoError(t, checkSourceCycles(cm.TempSources))
+
+	temp, err := cm.tempGetters[methodSource].GetTemp("hottest+5")
+	require.NoError(t, err)
+	require.Equal(t, float64(65), temp)
+
+	_, err = cm.tempGetters[methodSource].GetTemp("nope")
+	require.Error(t, err)
+
+	// reloads don't affect the readers (run with -race)
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 100; i++ {
+			_, _ = cm.sources.GetTemp("hottest+5")
+			_, _ = cm.sources.extract("fake", "cpu")
+		}
+		close(done)
+	}()
+	for i := 0; i < 100; i++ {
+		cm.sources.update(cm.TempSources, cm.tempGetters)
+	}
+	<-done
+
+	cm.TempSources["loop"] = &tempSource{Aggregate: AggregateMax, Sources: []*tempSource{
+		{Method: methodSource, Arg: "hottest"},
+		{Method: methodSource, Arg: "loop"},
+	}}
+	require.Error(t, checkSourceCycles(cm.TempSources))
+}
+
+func Test_tempSource_args(t *testing.T) {
+	ts := &tempSource{
+		Aggregate: AggregateMax,
+		Sources: []*tempSource{
+			{Method: "cli_stream", Arg: "a"},
+			{Method: methodDelta, Minuend: &tempSource{Method: "cli_stream", Arg: "b"}, Subtrahend: &tempSource{Method: "hwmon", Arg: "c"}},
+		},
+	}
+	require.Equal(t, []string{"a", "b"}, ts.args("cli_stream"))
+	require.Empty(t, ts.args("ipmi"))
+}
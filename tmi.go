@@ -1,9 +1,11 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"os"
 	"path/filepath"
 	"sort"
@@ -14,6 +16,8 @@ import (
 
 	"github.com/oblq/tmi/modules/cli"
 	"github.com/oblq/tmi/modules/commanderpro"
+	"github.com/oblq/tmi/modules/gpu"
+	"github.com/oblq/tmi/modules/hwmon"
 	"github.com/oblq/tmi/modules/ipmi"
 	"gopkg.in/yaml.v3"
 )
@@ -34,6 +38,42 @@ type fanController interface {
 	CheckConfig(path string)
 }
 
+// rpmReader is implemented by the fanControllers
+// able to read back the rpm of their channels.
+type rpmReader interface {
+	module
+	GetChannelRPM(ch uint8) (rpm uint16, err error)
+}
+
+// fanReader is implemented by the modules able to read
+// their fans tachometers by sensor name (eg.: ipmi `sdr type Fan`),
+// used for the targets with `fans` in targets_map.
+type fanReader interface {
+	module
+	GetFanRPMs() (rpms map[string]uint16, err error)
+}
+
+// rpmController is implemented by the fanControllers
+// able to hold a fixed rpm on their channels.
+type rpmController interface {
+	module
+	SetChannelFixedRPM(ch uint8, rpm uint16) error
+}
+
+// metricReader is implemented by the modules
+// exposing other readings than temperatures (eg.: voltages).
+type metricReader interface {
+	module
+	GetMetric(name string) (value float64, err error)
+}
+
+// timeouter is implemented by the modules
+// with a configurable requests timeout.
+type timeouter interface {
+	module
+	RequestTimeout() time.Duration
+}
+
 type closer interface {
 	module
 	Close()
@@ -41,37 +81,88 @@ type closer interface {
 
 // ---------------------------------------------------------------------------------------------------------------------
 
+// targetConfig is a targets_map entry, it could be a plain
+// `<fan_controller>.<channel>` string or a map with the step limits.
+type targetConfig struct {
+	Channel string `yaml:"channel"`
+
+	// MaxStepUp and MaxStepDown are the maximum duty-cycle change
+	// in percent per tick, 0 means no limit.
+	MaxStepUp   uint8 `yaml:"max_step_up"`
+	MaxStepDown uint8 `yaml:"max_step_down"`
+
+	// Fans are the fan sensors driven by the target, read back
+	// through its fanController as a fanReader (eg.: ipmi FAN1, FAN2),
+	// the lowest rpm is used as the target rpm.
+	Fans []string `yaml:"fans"`
+}
+
+func (tc *targetConfig) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		tc.Channel = value.Value
+		return nil
+	}
+
+	type plain targetConfig
+	return value.Decode((*plain)(tc))
+}
+
 type Target struct {
 	fanController fanController
 	channel       uint8
-}
 
-type ControlManager struct {
-	mutex   sync.Mutex
-	ticker  *time.Ticker
-	running bool
+	maxStepUp   uint8
+	maxStepDown uint8
 
-	configPath string
-	configStat os.FileInfo
+	fans []string
+}
 
+// limitStep return the duty-cycle to be set moving from
+// the current duty-cycle toward the needed one.
+func (t Target) limitStep(current, needed uint8) uint8 {
+	if needed > current && t.maxStepUp > 0 && needed-current > t.maxStepUp {
+		return current + t.maxStepUp
+	}
+	if needed < current && t.maxStepDown > 0 && current-needed > t.maxStepDown {
+		return current - t.maxStepDown
+	}
+	return needed
+}
+
+// Config is the tmi.yaml configuration.
+type Config struct {
 	ActiveModules struct {
 		Ipmi         bool
 		CommanderPro bool
+		Hwmon        bool
+		Nvidia       bool
+		AMDGPU       bool
 	} `yaml:"active_modules"`
 
 	// checkInterval is the time between checks, in seconds.
 	CheckInterval int `yaml:"check_interval"`
 
-	tempGetters    map[string]tempExtractor
-	fanControllers map[string]fanController
-	// modules that needs to be closed
-	closers map[string]closer
-
 	// a map containing arbitrary names associated with a fanController:channel couple.
 	// eg.: `pump: ipmi.0` or `side: commanderpro.2`
-	TargetsMap map[string]string `yaml:"targets_map"`
-	// prepared target list with parsed fanController and channel
-	targets map[string]Target
+	// or `side: {channel: commanderpro.2, max_step_up: 20, max_step_down: 5}`
+	TargetsMap map[string]targetConfig `yaml:"targets_map"`
+
+	// Cli are the cli temp method settings.
+	Cli struct {
+		// Timeout is the commands timeout, in seconds.
+		Timeout int `yaml:"timeout"`
+	} `yaml:"cli"`
+
+	// CliStream are the cli_stream temp method settings.
+	CliStream struct {
+		// MaxAge is the maximum age of a streamed value, in seconds,
+		// older values are reported as errors and silent commands are restarted.
+		MaxAge int `yaml:"max_age"`
+	} `yaml:"cli_stream"`
+
+	// TempSources are named temp sources, usable by the modules
+	// with the `source` method and the source name as arg.
+	TempSources map[string]*tempSource `yaml:"temp_sources"`
 
 	// Controllers is an array of controllers.
 	// A controller consist in a struct describing the
@@ -80,9 +171,95 @@ type ControlManager struct {
 	// one or most specified targets.
 	Controllers []*controller `yaml:"controllers"`
 
+	// Emergency force all the targets to 100%
+	// when a controller temp exceeds its critical value.
+	Emergency emergency `yaml:"emergency"`
+
+	// Alerts watch the modules metrics (eg.: voltages).
+	Alerts []*alert `yaml:"alerts"`
+
+	// StallDetection raise a "fan stalled" condition
+	// when the rpm of a running target stays too low.
+	StallDetection stallDetection `yaml:"stall_detection"`
+}
+
+type ControlManager struct {
+	mutex   sync.Mutex
+	ticker  *time.Ticker
+	running bool
+
+	configPath string
+	configStat os.FileInfo
+
+	// Config is the running config, replaced
+	// on hot reload only if the new one is valid.
+	Config `yaml:",inline"`
+
+	tempGetters    map[string]tempExtractor
+	fanControllers map[string]fanController
+	metricReaders  map[string]metricReader
+	// modules that needs to be closed
+	closers map[string]closer
+
+	// prepared target list with parsed fanController and channel
+	targets map[string]Target
+
+	cli       *cli.Cli
+	cliStream *cli.Stream
+	sources   *sources
+	metrics   *metrics
+
 	// targetsDutyCycle represent the currently used
 	// duty-cycle for any given target.
 	targetsDutyCycle map[string]uint8
+
+	// targetsRPM represent the currently used
+	// fixed rpm for the rpmController targets.
+	targetsRPM map[string]uint16
+
+	// fanRPMs are the fanReader readings
+	// of the current check, by module name.
+	fanRPMs map[string]map[string]uint16
+
+	// dutyChanges are the duty-cycle changes
+	// to be verified in the next check.
+	dutyChanges map[string]dutyChange
+
+	// timeoutWarnings are the last printed modules timeouts warnings.
+	timeoutWarnings string
+
+	// emergencyActive is the Emergency override state,
+	// kept across the config reloads.
+	emergencyActive bool
+}
+
+// dutyChange is a duty-cycle change,
+// with the target rpm before the change.
+type dutyChange struct {
+	from, to uint8
+	rpm      uint16
+}
+
+// verifyMinDutyChange is the minimum duty-cycle change (in %)
+// expected to change the rpm of the target.
+const verifyMinDutyChange = 10
+
+// verifiable return true if the change is expected to move the rpm,
+// changes to 100% (the fans can be at their top speed already)
+// or to 0% (stop or automatic mode) are not verified.
+func (c dutyChange) verifiable() bool {
+	if c.to == 0 || c.to == 100 {
+		return false
+	}
+	return math.Abs(float64(c.to)-float64(c.from)) >= verifyMinDutyChange
+}
+
+// tookEffect return true if the rpm moved in the direction of the change.
+func (c dutyChange) tookEffect(rpm uint16) bool {
+	if c.to > c.from {
+		return rpm > c.rpm
+	}
+	return rpm < c.rpm
 }
 
 func New(configPath string) (cm *ControlManager, err error) {
@@ -90,14 +267,23 @@ func New(configPath string) (cm *ControlManager, err error) {
 		configPath:       configPath,
 		tempGetters:      make(map[string]tempExtractor),
 		fanControllers:   make(map[string]fanController),
+		metricReaders:    make(map[string]metricReader),
 		closers:          make(map[string]closer),
 		targets:          make(map[string]Target),
-		Controllers:      make([]*controller, 0),
+		Config:           Config{Controllers: make([]*controller, 0)},
 		targetsDutyCycle: make(map[string]uint8),
+		targetsRPM:       make(map[string]uint16),
+		dutyChanges:      make(map[string]dutyChange),
 	}
 
-	cliInterface := &cli.Cli{}
-	cm.addModule(cliInterface)
+	cm.cli = &cli.Cli{}
+	cm.addModule(cm.cli)
+	cm.cliStream = cli.NewStream()
+	cm.addModule(cm.cliStream)
+	cm.sources = &sources{}
+	cm.addModule(cm.sources)
+	cm.metrics = &metrics{}
+	cm.addModule(cm.metrics)
 
 	return
 }
@@ -111,6 +297,10 @@ func (cm *ControlManager) addModule(module interface{}) {
 		cm.fanControllers[fc.Name()] = fc
 	}
 
+	if mr, ok := module.(metricReader); ok {
+		cm.metricReaders[mr.Name()] = mr
+	}
+
 	if c, ok := module.(closer); ok {
 		cm.closers[c.Name()] = c
 	}
@@ -126,31 +316,46 @@ func (cm *ControlManager) hasModule(moduleName string) bool {
 	return false
 }
 
-// LoadConfig will do a hot reload of the
-// program configuration.
+// LoadConfigAndStart will do a hot reload of the
+// program configuration. An invalid configuration is discarded,
+// the previous one, if any, keeps running.
 func (cm *ControlManager) LoadConfigAndStart() (err error) {
-	cm.mutex.Lock()
+	if err = cm.loadConfig(); err != nil {
+		return
+	}
 
-	cm.StopMonitoring()
+	for _, fc := range cm.fanControllers {
+		fc.CheckConfig(cm.configPath)
+	}
+	cm.checkModulesTimeouts()
+
+	cm.StartMonitoring()
+
+	return
+}
+
+// loadConfig read, validate and apply tmi.yaml,
+// the monitoring is stopped once the config is applied.
+func (cm *ControlManager) loadConfig() (err error) {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
 
 	configPath := filepath.Join(cm.configPath, "tmi.yaml")
 	if cm.configStat, err = os.Stat(configPath); err != nil {
 		return
 	}
 
-	config, err := ioutil.ReadFile(configPath)
+	data, err := ioutil.ReadFile(configPath)
 	if err != nil {
 		return
 	}
-	err = yaml.Unmarshal(config, &cm)
-	if err != nil {
+	var config Config
+	if err = yaml.Unmarshal(data, &config); err != nil {
 		return
 	}
 
-	fmt.Println("config updated")
-
 	// update modules
-	if cm.ActiveModules.Ipmi && !cm.hasModule("ipmi") {
+	if config.ActiveModules.Ipmi && !cm.hasModule("ipmi") {
 		var ipmiInterface *ipmi.IPMI
 		ipmiInterface, err = ipmi.New()
 		if err != nil {
@@ -159,63 +364,184 @@ func (cm *ControlManager) LoadConfigAndStart() (err error) {
 		cm.addModule(ipmiInterface)
 	}
 
-	if cm.ActiveModules.CommanderPro && !cm.hasModule("commanderpro") {
+	if config.ActiveModules.CommanderPro && !cm.hasModule("commanderpro") {
 		var cpInterface *commanderpro.CommanderPro
 		cpInterface, err = commanderpro.Open()
 		if err != nil {
 			return fmt.Errorf("unable to open connection to Corsair Commander Pro: " + err.Error())
 		}
-		cpInterface.GetExternalTemp = func(method, arg string) (temp float64, err error) {
-			tg, ok := cm.tempGetters[method]
-			if !ok {
-				return 0, fmt.Errorf("no such temp method: %s, defined in commanderpro config", method)
-			}
-
-			return tg.GetTemp(arg)
-		}
+		cpInterface.GetExternalTemp = cm.sources.extract
 		cm.addModule(cpInterface)
 	}
 
-	// reset values
-	cm.targetsDutyCycle = make(map[string]uint8)
+	if config.ActiveModules.Hwmon && !cm.hasModule("hwmon") {
+		cm.addModule(hwmon.New(hwmon.DefaultRoot))
+	}
 
-	err = cm.parseTargetsMap()
+	if config.ActiveModules.Nvidia && !cm.hasModule("nvidia") {
+		cm.addModule(gpu.NewNvidia())
+	}
 
-	cm.mutex.Unlock()
+	if config.ActiveModules.AMDGPU && !cm.hasModule("amdgpu") {
+		cm.addModule(gpu.NewAMDGPU(gpu.DefaultDRMRoot))
+	}
 
-	for _, fc := range cm.fanControllers {
-		fc.CheckConfig(cm.configPath)
+	targets, err := cm.parseTargetsMap(config.TargetsMap)
+	if err == nil {
+		err = config.validate()
+	}
+	if err != nil {
+		return fmt.Errorf("invalid config, not applied: %v", err)
 	}
 
-	cm.StartMonitoring()
+	fmt.Println("config updated")
+
+	cm.StopMonitoring()
+
+	cm.Config = config
+	cm.targets = targets
+	cm.sources.update(cm.TempSources, cm.tempGetters)
+	cm.metrics.update(cm.metricReaders)
+
+	cm.cli.SetTimeout(time.Second * time.Duration(cm.Cli.Timeout))
+
+	streamMaxAge := cli.DefaultStreamMaxAge
+	if cm.CliStream.MaxAge > 0 {
+		streamMaxAge = time.Second * time.Duration(cm.CliStream.MaxAge)
+	}
+	cm.cliStream.SetMaxAge(streamMaxAge)
+	cm.cliStream.Retain(cm.tempSourceArgs(cm.cliStream.Name()))
+
+	// reset values
+	cm.targetsDutyCycle = make(map[string]uint8)
+	cm.targetsRPM = make(map[string]uint16)
+	cm.dutyChanges = make(map[string]dutyChange)
 
 	return
 }
 
-func (cm *ControlManager) parseTargetsMap() (err error) {
-	for key, couple := range cm.TargetsMap {
-		coupleArr := strings.SplitN(couple, ".", 2)
+// parseTargetsMap return the targets of targetsMap.
+func (cm *ControlManager) parseTargetsMap(targetsMap map[string]targetConfig) (map[string]Target, error) {
+	targets := make(map[string]Target)
+	for key, tc := range targetsMap {
+		coupleArr := strings.SplitN(tc.Channel, ".", 2)
 		if len(coupleArr) < 2 {
-			err = errors.New("target_map value must be a string containing the target and one of its channels separated by a dot. eg.: `ipmi.0` ")
-			return
+			return nil, errors.New("target_map value must be a string containing the target and one of its channels separated by a dot. eg.: `ipmi.0` ")
 		}
 		fanControllerName := coupleArr[0]
 		fanController, ok := cm.fanControllers[fanControllerName]
 		if !ok {
-			return fmt.Errorf("no such target %s", fanControllerName)
+			return nil, fmt.Errorf("no such target %s", fanControllerName)
 		}
-		var fanControllerChannel int
-		fanControllerChannel, err = strconv.Atoi(coupleArr[1])
+		fanControllerChannel, err := strconv.Atoi(coupleArr[1])
 		if err != nil {
-			return fmt.Errorf("target channel for %s is not a valid integer", fanControllerName)
+			return nil, fmt.Errorf("target channel for %s is not a valid integer", fanControllerName)
 		}
-		cm.targets[key] = Target{
+		if _, ok := fanController.(fanReader); len(tc.Fans) > 0 && !ok {
+			return nil, fmt.Errorf("target %s: %s can't read fans rpm", key, fanControllerName)
+		}
+		targets[key] = Target{
 			fanController: fanController,
 			channel:       uint8(fanControllerChannel),
+			maxStepUp:     tc.MaxStepUp,
+			maxStepDown:   tc.MaxStepDown,
+			fans:          tc.Fans,
 		}
 	}
 
-	return
+	return targets, nil
+}
+
+// tempSourceArgs return the args of the configured temp sources using method,
+// the ones used by other modules (eg.: commanderpro external temps) are not included.
+func (config *Config) tempSourceArgs(method string) (args []string) {
+	for _, ts := range config.TempSources {
+		args = append(args, ts.args(method)...)
+	}
+	for _, c := range config.Controllers {
+		args = append(args, c.Temp.args(method)...)
+	}
+	return args
+}
+
+// validate check the temp sources and the controllers.
+func (config *Config) validate() error {
+	if config.CheckInterval <= 0 {
+		return errors.New("check_interval must be positive")
+	}
+	cliTimeout := cli.DefaultTimeout
+	if config.Cli.Timeout > 0 {
+		cliTimeout = time.Second * time.Duration(config.Cli.Timeout)
+	}
+	if err := config.checkTimeout("cli", cliTimeout); err != nil {
+		return err
+	}
+
+	for name, ts := range config.TempSources {
+		if err := ts.validate(); err != nil {
+			return fmt.Errorf("temp source %s: %v", name, err)
+		}
+	}
+	if err := checkSourceCycles(config.TempSources); err != nil {
+		return err
+	}
+
+	// target : mapped in rpm
+	useRPM := make(map[string]bool)
+	checkUnit := func(c *controller, target string, rpm bool) error {
+		if prev, ok := useRPM[target]; ok && prev != rpm {
+			return fmt.Errorf("controller %s: target %s is mapped both in duty-cycle and rpm", c.Name, target)
+		}
+		useRPM[target] = rpm
+		return nil
+	}
+
+	for _, c := range config.Controllers {
+		if err := c.validate(); err != nil {
+			return err
+		}
+		for target := range c.Targets {
+			if err := checkUnit(c, target, false); err != nil {
+				return err
+			}
+		}
+		for target := range c.RPMTargets {
+			if err := checkUnit(c, target, true); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := config.Emergency.validate(config.Controllers); err != nil {
+		return err
+	}
+	return config.StallDetection.validate()
+}
+
+// checkTimeout check that a timeout is shorter than check_interval,
+// a hung command would delay the next check otherwise.
+func (config *Config) checkTimeout(name string, timeout time.Duration) error {
+	if interval := time.Second * time.Duration(config.CheckInterval); timeout >= interval {
+		return fmt.Errorf("%s timeout (%s) must be shorter than check_interval (%s)", name, timeout, interval)
+	}
+	return nil
+}
+
+// checkModulesTimeouts warn about the modules timeouts not shorter than check_interval,
+// they are read from the modules own config so the warning is printed once per change.
+func (cm *ControlManager) checkModulesTimeouts() {
+	var warnings string
+	for _, fc := range cm.fanControllers {
+		if t, ok := fc.(timeouter); ok {
+			if err := cm.checkTimeout(fc.Name(), t.RequestTimeout()); err != nil {
+				warnings += "warning: " + err.Error() + "\n"
+			}
+		}
+	}
+	if warnings != cm.timeoutWarnings {
+		cm.timeoutWarnings = warnings
+		fmt.Print(warnings)
+	}
 }
 
 func (cm *ControlManager) checkConfig() {
@@ -236,6 +562,7 @@ func (cm *ControlManager) checkConfig() {
 	for _, fc := range cm.fanControllers {
 		fc.CheckConfig(cm.configPath)
 	}
+	cm.checkModulesTimeouts()
 }
 
 // StartMonitoring start the monitoring daemon,
@@ -251,9 +578,10 @@ func (cm *ControlManager) StartMonitoring() {
 	if cm.ticker != nil {
 		cm.ticker.Stop()
 	}
-	cm.ticker = time.NewTicker(time.Second * time.Duration(cm.CheckInterval))
+	ticker := time.NewTicker(time.Second * time.Duration(cm.CheckInterval))
+	cm.ticker = ticker
 	go func() {
-		for range cm.ticker.C {
+		for range ticker.C {
 			cm.check()
 			cm.checkConfig()
 		}
@@ -268,36 +596,101 @@ func (cm *ControlManager) StopMonitoring() {
 	cm.running = false
 }
 
+// Close stop the daemon and close the modules,
+// restoring their original state, after the running check, if any.
+func (cm *ControlManager) Close() {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+
+	cm.StopMonitoring()
+	for _, c := range cm.closers {
+		c.Close()
+	}
+}
+
 func (cm *ControlManager) check() {
 	logString := "	| "
 
 	cm.mutex.Lock()
 
+	// stopped while waiting for the lock
+	if !cm.running {
+		cm.mutex.Unlock()
+		return
+	}
+
+	cm.fanRPMs = make(map[string]map[string]uint16)
+
 	// grab the greater values divided by zone first
 	tempTargetsDutyCycles := make(map[string]uint8)
-	for _, controller := range cm.Controllers {
-		tg, ok := cm.tempGetters[controller.Temp.Method]
-		if !ok {
-			fmt.Println("no such temp method: " + controller.Temp.Method)
-			continue
+	tempTargetsRPMs := make(map[string]uint16)
+	// grab the maximum needed dc (or rpm) value for every target
+	grabMax := func(targetsData map[string]*targetData) {
+		for target, targetData := range targetsData {
+			if targetData.useRPM {
+				if targetData.rpm >= tempTargetsRPMs[target] {
+					tempTargetsRPMs[target] = targetData.rpm
+				}
+			} else if targetData.dutyCycle >= tempTargetsDutyCycles[target] {
+				tempTargetsDutyCycles[target] = targetData.dutyCycle
+			}
 		}
+	}
 
-		temp, err := tg.GetTemp(controller.Temp.Arg)
+	// controllers temp, by name
+	temps := make(map[string]float64)
+	readings := cm.readTemps()
+	for i, controller := range cm.Controllers {
+		temp, err := readings[i].temp, readings[i].err
 		if err != nil {
 			fmt.Println("error getting temperature for", controller.Name, "->", err.Error())
+			logString += fmt.Sprintf("%s %5s | ", controller.Name, "err")
+
+			grabMax(controller.onTempError())
 			continue
 		}
 
-		logString += fmt.Sprintf("%s %5s | ", controller.Name, fmt.Sprint(temp)+"°C")
+		if failures := controller.onTempRecovered(); failures > 0 {
+			fmt.Println("temperature for", controller.Name, "recovered after", failures, "failed readings")
+		}
 
-		// grab the maximum needed dc value for every target
-		for target, targetData := range controller.getNeededDutyCycles(temp) {
-			if targetData.dutyCycle >= tempTargetsDutyCycles[target] {
-				tempTargetsDutyCycles[target] = targetData.dutyCycle
+		temp = controller.Filter.apply(temp)
+		temps[controller.Name] = temp
+
+		logString += fmt.Sprintf("%s %5s | ", controller.Name, fmt.Sprint(math.Round(temp*10)/10)+"°C")
+
+		grabMax(controller.getNeededDutyCycles(temp))
+	}
+
+	if active := cm.Emergency.update(cm.emergencyActive, temps); active != cm.emergencyActive {
+		cm.emergencyActive = active
+		if active {
+			fmt.Println("EMERGENCY: critical temperature reached, all targets forced to 100%")
+			go runHook("emergency", cm.Emergency.Command)
+		} else {
+			fmt.Println("emergency released")
+		}
+	}
+
+	if cm.emergencyActive {
+		for target := range cm.targets {
+			tempTargetsDutyCycles[target] = 100
+		}
+	}
+
+	if cm.StallDetection.Enabled && cm.StallDetection.Compensate && cm.StallDetection.anyStalled() {
+		for target := range cm.targets {
+			if !cm.StallDetection.stalled[target] {
+				tempTargetsDutyCycles[target] = 100
 			}
 		}
 	}
 
+	cm.setRPMs(tempTargetsRPMs, tempTargetsDutyCycles)
+
+	// duty-cycle changes made in this check
+	changes := make(map[string]dutyChange)
+
 	// set the needed duty cycle if different from the current value
 	for target, dc := range tempTargetsDutyCycles {
 		t, ok := cm.targets[target]
@@ -306,7 +699,21 @@ func (cm *ControlManager) check() {
 			continue
 		}
 
+		if current, ok := cm.targetsDutyCycle[target]; ok && !cm.emergencyActive {
+			dc = t.limitStep(current, dc)
+		}
+
+		delete(cm.targetsRPM, target)
+
 		if dc == 0 || cm.targetsDutyCycle[target] != dc {
+			if from, ok := cm.targetsDutyCycle[target]; ok {
+				if change := (dutyChange{from: from, to: dc}); change.verifiable() {
+					if rpm, ok, err := cm.targetRPM(t); err == nil && ok {
+						change.rpm = rpm
+						changes[target] = change
+					}
+				}
+			}
 			cm.targetsDutyCycle[target] = dc
 
 			//fmt.Printf("Updating '%s' zone duty cycle to: %d%%\n", zone, pwm)
@@ -327,14 +734,39 @@ func (cm *ControlManager) check() {
 		}
 	}
 
+	// the rpm is read only if used, by the stall detection
+	// or to verify the duty-cycle changes of the last check
+	rpmTargets := make(map[string]bool)
+	for target := range cm.targets {
+		if _, verify := cm.dutyChanges[target]; verify || cm.StallDetection.Enabled {
+			rpmTargets[target] = true
+		}
+	}
+	rpms := cm.readTargetsRPM(rpmTargets)
+
+	if cm.StallDetection.Enabled {
+		cm.checkStalls(rpms)
+	}
+
+	cm.verifyDutyChanges(rpms, changes)
+
+	cm.checkAlerts()
+
 	cm.mutex.Unlock()
 
 	logString += "	->	| "
 
 	targets := make([]string, 0)
 	for target, dc := range cm.targetsDutyCycle {
+		if rpm, ok := rpms[target]; ok {
+			targets = append(targets, fmt.Sprintf("%s %d%% %drpm | ", target, dc, rpm))
+			continue
+		}
 		targets = append(targets, fmt.Sprintf("%s %d%% | ", target, dc))
 	}
+	for target, rpm := range cm.targetsRPM {
+		targets = append(targets, fmt.Sprintf("%s %drpm | ", target, rpm))
+	}
 	sort.Strings(targets)
 	for _, log := range targets {
 		logString += log
@@ -342,3 +774,225 @@ func (cm *ControlManager) check() {
 
 	fmt.Println(logString)
 }
+
+// setRPMs set the needed rpm for the rpm targets.
+// rpmController targets hold the rpm natively, the targets able to read
+// their rpm approximate it through their duty-cycle, which is added to dutyCycles.
+// Duty-cycles already in dutyCycles (emergency, failsafe...) take precedence.
+func (cm *ControlManager) setRPMs(rpms map[string]uint16, dutyCycles map[string]uint8) {
+	for target, rpm := range rpms {
+		if _, ok := dutyCycles[target]; ok {
+			continue
+		}
+
+		t, ok := cm.targets[target]
+		if !ok {
+			fmt.Println("no such target: " + target)
+			continue
+		}
+
+		if rc, ok := t.fanController.(rpmController); ok {
+			delete(cm.targetsDutyCycle, target)
+			if current, ok := cm.targetsRPM[target]; !ok || current != rpm {
+				cm.targetsRPM[target] = rpm
+				if err := rc.SetChannelFixedRPM(t.channel, rpm); err != nil {
+					fmt.Println(err.Error())
+				}
+			}
+			continue
+		}
+
+		curRPM, ok, err := cm.targetRPM(t)
+		if err != nil {
+			fmt.Println("unable to read rpm for", target, "->", err.Error())
+			continue
+		}
+		if !ok {
+			fmt.Println("target", target, "does not support rpm control")
+			continue
+		}
+		dutyCycles[target] = approximateRPM(cm.targetsDutyCycle[target], curRPM, rpm)
+	}
+}
+
+// targetRPM read the current rpm of a target, ok is false if the target
+// can't read back its rpm. Targets with fans use the lowest rpm
+// of their fans, read once per check through the fanReader.
+func (cm *ControlManager) targetRPM(t Target) (rpm uint16, ok bool, err error) {
+	if fr, isReader := t.fanController.(fanReader); isReader && len(t.fans) > 0 {
+		if cm.fanRPMs == nil {
+			cm.fanRPMs = make(map[string]map[string]uint16)
+		}
+
+		fans, read := cm.fanRPMs[fr.Name()]
+		if !read {
+			if fans, err = fr.GetFanRPMs(); err != nil {
+				return 0, false, err
+			}
+			cm.fanRPMs[fr.Name()] = fans
+		}
+
+		for i, fan := range t.fans {
+			fanRPM, found := fans[fan]
+			if !found {
+				return 0, false, fmt.Errorf("no reading for fan %s", fan)
+			}
+			if i == 0 || fanRPM < rpm {
+				rpm = fanRPM
+			}
+		}
+		return rpm, true, nil
+	}
+
+	if rr, isReader := t.fanController.(rpmReader); isReader {
+		rpm, err = rr.GetChannelRPM(t.channel)
+		return rpm, err == nil, err
+	}
+
+	return 0, false, nil
+}
+
+// readTargetsRPM return the current rpm of the given targets able to read it.
+func (cm *ControlManager) readTargetsRPM(targets map[string]bool) map[string]uint16 {
+	rpms := make(map[string]uint16)
+	for target := range targets {
+		t, ok := cm.targets[target]
+		if !ok {
+			continue
+		}
+		rpm, ok, err := cm.targetRPM(t)
+		if err != nil {
+			fmt.Println("unable to read rpm for", target, "->", err.Error())
+			continue
+		}
+		if ok {
+			rpms[target] = rpm
+		}
+	}
+	return rpms
+}
+
+// verifyDutyChanges warn about the duty-cycle changes of the last check
+// which didn't move the rpm, then keep the changes to be verified in the next one.
+// Targets changed again in this check are not verified.
+func (cm *ControlManager) verifyDutyChanges(rpms map[string]uint16, changes map[string]dutyChange) {
+	for target, change := range cm.dutyChanges {
+		if _, changed := changes[target]; changed {
+			continue
+		}
+		rpm, ok := rpms[target]
+		if !ok {
+			continue
+		}
+		if !change.tookEffect(rpm) {
+			fmt.Printf("duty-cycle change for %s from %d%% to %d%% had no effect, still at %d rpm\n",
+				target, change.from, change.to, rpm)
+		}
+	}
+	cm.dutyChanges = changes
+}
+
+const (
+	// approximateRPM tolerance, in percent of the needed rpm.
+	rpmTolerance = 3
+	// approximateRPM duty-cycle change per percent of rpm error.
+	rpmGain = 0.5
+)
+
+// approximateRPM return the duty-cycle moving
+// the current rpm toward the needed one.
+func approximateRPM(dc uint8, rpm, needed uint16) uint8 {
+	if needed == 0 {
+		return 0
+	}
+
+	errPct := (float64(needed) - float64(rpm)) / float64(needed) * 100
+	if math.Abs(errPct) < rpmTolerance {
+		return dc
+	}
+
+	newDC := math.Round(float64(dc) + errPct*rpmGain)
+	return uint8(math.Max(1, math.Min(100, newDC)))
+}
+
+// checkStalls update the stalled condition
+// of the running targets with their rpm.
+func (cm *ControlManager) checkStalls(rpms map[string]uint16) {
+	// target : expected to spin
+	running := make(map[string]bool)
+	for target, dc := range cm.targetsDutyCycle {
+		running[target] = dc > 0
+	}
+	for target, rpm := range cm.targetsRPM {
+		running[target] = rpm > 0
+	}
+
+	for target, spinning := range running {
+		rpm, ok := rpms[target]
+		if !ok {
+			continue
+		}
+
+		if !spinning {
+			if cm.StallDetection.reset(target) {
+				fmt.Printf("fan %s stopped, stalled condition cleared\n", target)
+			}
+			continue
+		}
+
+		if cm.StallDetection.update(target, rpm) {
+			if cm.StallDetection.stalled[target] {
+				fmt.Printf("FAN STALLED: %s is at %d rpm\n", target, rpm)
+				go runHook("fan stalled", strings.Replace(cm.StallDetection.Command, "{target}", target, -1))
+			} else {
+				fmt.Printf("fan %s recovered, %d rpm\n", target, rpm)
+			}
+		}
+	}
+}
+
+// tempReading is a controller temp source reading.
+type tempReading struct {
+	temp float64
+	err  error
+}
+
+// readTemps read the temp of every controller concurrently,
+// a stuck module delays the check by its timeout only once.
+func (cm *ControlManager) readTemps() []tempReading {
+	readings := make([]tempReading, len(cm.Controllers))
+
+	var wg sync.WaitGroup
+	for i, c := range cm.Controllers {
+		wg.Add(1)
+		go func(i int, c *controller) {
+			defer wg.Done()
+			readings[i].temp, readings[i].err = c.Temp.getTemp(cm.tempGetters)
+		}(i, c)
+	}
+	wg.Wait()
+
+	return readings
+}
+
+// hookTimeout is the timeout of the hooks commands, they run detached
+// from the checks so they are not bound to the cli timeout.
+const hookTimeout = 10 * time.Minute
+
+// runHook execute a user configured shell command, if any,
+// through the cli module. It blocks until the command exits,
+// call it in a goroutine.
+func runHook(name, command string) {
+	if command == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+	defer cancel()
+	out, err := cli.CommandPipeContext(ctx, command)
+	if err != nil {
+		fmt.Println(name, "hook failed:", err.Error())
+		return
+	}
+	fmt.Println(name, "hook executed:", out)
+}
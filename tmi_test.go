@@ -0,0 +1,268 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func Test_targetConfig_UnmarshalYAML(t *testing.T) {
+	config := []byte(`
+targets_map:
+  pump: ipmi.0
+  side: {channel: commanderpro.0, max_step_up: 30, max_step_down: 5}
+`)
+
+	cm := &ControlManager{}
+	require.NoError(t, yaml.Unmarshal(config, cm))
+	require.Equal(t, map[string]targetConfig{
+		"pump": {Channel: "ipmi.0"},
+		"side": {Channel: "commanderpro.0", MaxStepUp: 30, MaxStepDown: 5},
+	}, cm.TargetsMap)
+}
+
+func Test_Target_limitStep(t *testing.T) {
+	target := Target{maxStepUp: 30, maxStepDown: 5}
+
+	tests := []struct {
+		name    string
+		current uint8
+		needed  uint8
+		want    uint8
+	}{
+		{name: "up within limit", current: 30, needed: 50, want: 50},
+		{name: "up over limit", current: 30, needed: 100, want: 60},
+		{name: "down within limit", current: 50, needed: 46, want: 46},
+		{name: "down over limit", current: 100, needed: 30, want: 95},
+		{name: "down to zero", current: 3, needed: 0, want: 0},
+		{name: "unchanged", current: 40, needed: 40, want: 40},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, target.limitStep(tt.current, tt.needed))
+		})
+	}
+
+	require.Equal(t, uint8(100), Target{}.limitStep(0, 100))
+}
+
+func Test_approximateRPM(t *testing.T) {
+	tests := []struct {
+		name   string
+		dc     uint8
+		rpm    uint16
+		needed uint16
+		want   uint8
+	}{
+		{name: "within tolerance", dc: 40, rpm: 1010, needed: 1000, want: 40},
+		{name: "too slow", dc: 40, rpm: 800, needed: 1000, want: 50},
+		{name: "too fast", dc: 40, rpm: 1200, needed: 1000, want: 30},
+		{name: "from zero", dc: 0, rpm: 0, needed: 1000, want: 50},
+		{name: "clamped", dc: 90, rpm: 0, needed: 1000, want: 100},
+		{name: "stop", dc: 40, rpm: 1000, needed: 0, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, approximateRPM(tt.dc, tt.rpm, tt.needed))
+		})
+	}
+}
+
+// fakeFanReader is a fanController reading its fans by name,
+// reads counts the GetFanRPMs calls.
+type fakeFanReader struct {
+	rpms  map[string]uint16
+	reads int
+}
+
+func (f *fakeFanReader) Name() string { return "fake" }
+
+func (f *fakeFanReader) SetChannelDutyCycle(ch uint8, dc uint8) error { return nil }
+
+func (f *fakeFanReader) GetChannelDutyCycle(ch uint8) (uint8, error) { return 0, nil }
+
+func (f *fakeFanReader) CheckConfig(path string) {}
+
+func (f *fakeFanReader) GetFanRPMs() (map[string]uint16, error) {
+	f.reads++
+	return f.rpms, nil
+}
+
+func TestControlManager_readTargetsRPM(t *testing.T) {
+	reader := &fakeFanReader{rpms: map[string]uint16{"FAN1": 1200, "FAN2": 900, "FANA": 600}}
+	cm := &ControlManager{targets: map[string]Target{
+		"cpu":     {fanController: reader, channel: 0, fans: []string{"FAN1", "FAN2"}},
+		"pci":     {fanController: reader, channel: 1, fans: []string{"FANA"}},
+		"no_fans": {fanController: reader, channel: 2},
+		"missing": {fanController: reader, channel: 3, fans: []string{"FANB"}},
+	}}
+
+	all := map[string]bool{"cpu": true, "pci": true, "no_fans": true, "missing": true}
+	require.Equal(t, map[string]uint16{"cpu": 900, "pci": 600}, cm.readTargetsRPM(all))
+	require.Equal(t, 1, reader.reads)
+
+	cm.fanRPMs = nil
+	require.Empty(t, cm.readTargetsRPM(nil))
+	require.Equal(t, 1, reader.reads, "nothing to read")
+}
+
+func Test_dutyChange_verifiable(t *testing.T) {
+	require.True(t, dutyChange{from: 30, to: 60}.verifiable())
+	require.False(t, dutyChange{from: 30, to: 35}.verifiable(), "too small")
+	require.False(t, dutyChange{from: 60, to: 100}.verifiable(), "saturated")
+	require.False(t, dutyChange{from: 30, to: 0}.verifiable(), "stopped")
+}
+
+func Test_dutyChange_tookEffect(t *testing.T) {
+	require.True(t, dutyChange{from: 30, to: 60, rpm: 800}.tookEffect(1200))
+	require.False(t, dutyChange{from: 30, to: 60, rpm: 800}.tookEffect(800))
+	require.True(t, dutyChange{from: 60, to: 30, rpm: 1200}.tookEffect(800))
+	require.False(t, dutyChange{from: 60, to: 30, rpm: 1200}.tookEffect(1300))
+}
+
+func TestControlManager_LoadConfigAndStart_invalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tmi")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	writeConfig := func(interpolation string) {
+		config := `
+check_interval: 60
+controllers:
+  - name: CPU
+    interpolation: ` + interpolation + `
+    temp: {method: cli, arg: echo 40}
+`
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "tmi.yaml"), []byte(config), 0644))
+	}
+
+	cm, err := New(dir)
+	require.NoError(t, err)
+	defer cm.Close()
+
+	writeConfig("linear")
+	require.NoError(t, cm.LoadConfigAndStart())
+
+	writeConfig("nope")
+	require.Error(t, cm.LoadConfigAndStart())
+
+	// the previous config keeps running
+	require.True(t, cm.running)
+	require.Len(t, cm.Controllers, 1)
+	require.Equal(t, InterpolationLinear, cm.Controllers[0].Interpolation)
+}
+
+func TestConfig_validate_timeouts(t *testing.T) {
+	config := Config{CheckInterval: 6}
+	require.NoError(t, config.validate(), "default cli timeout")
+
+	config.Cli.Timeout = 6
+	require.Error(t, config.validate())
+
+	config.CheckInterval = 10
+	require.NoError(t, config.validate())
+
+	config.CheckInterval = 0
+	require.Error(t, config.validate())
+}
+
+func TestControlManager_Close_afterFailedReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tmi")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	configPath := filepath.Join(dir, "tmi.yaml")
+	require.NoError(t, ioutil.WriteFile(configPath, []byte("check_interval: 60\n"), 0644))
+
+	cm, err := New(dir)
+	require.NoError(t, err)
+	require.NoError(t, cm.LoadConfigAndStart())
+
+	for _, config := range []string{"check_interval: [", "check_interval: 0\n"} {
+		require.NoError(t, ioutil.WriteFile(configPath, []byte(config), 0644))
+		require.Error(t, cm.LoadConfigAndStart())
+	}
+	require.NoError(t, os.Remove(configPath))
+	require.Error(t, cm.LoadConfigAndStart())
+
+	closed := make(chan struct{})
+	go func() {
+		cm.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close blocked after a failed reload")
+	}
+}
+
+func TestControlManager_reload_concurrentExtract(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tmi")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "tmi.yaml"), []byte("check_interval: 60\n"), 0644))
+
+	cm, err := New(dir)
+	require.NoError(t, err)
+	defer cm.Close()
+
+	// as the commanderpro external temps feeder does
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			_, _ = cm.sources.extract("cli", "echo 40")
+			_, _ = cm.sources.extract(methodMetric, "nope.12v")
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		require.NoError(t, cm.LoadConfigAndStart())
+	}
+	<-done
+}
+
+func TestControlManager_reload_keepsEmergency(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tmi")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	hookPath := filepath.Join(dir, "hook")
+	config := `
+check_interval: 60
+controllers:
+  - name: CPU
+    temp: {method: cli, arg: echo 95}
+emergency:
+  critical: {CPU: 90}
+  release: 10
+  command: echo x >> ` + hookPath + `
+`
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "tmi.yaml"), []byte(config), 0644))
+
+	cm, err := New(dir)
+	require.NoError(t, err)
+	defer cm.Close()
+
+	require.NoError(t, cm.LoadConfigAndStart())
+	require.True(t, cm.emergencyActive)
+
+	require.NoError(t, cm.LoadConfigAndStart())
+	cm.check()
+	require.True(t, cm.emergencyActive)
+
+	hookRuns := func() string {
+		data, _ := ioutil.ReadFile(hookPath)
+		return string(data)
+	}
+	require.Eventually(t, func() bool { return hookRuns() != "" }, 5*time.Second, 10*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	require.Equal(t, "x\n", hookRuns(), "the hook runs once")
+}